@@ -0,0 +1,123 @@
+package evdev
+
+import "math"
+
+// Suppresses jitter on absolute axes. For each configured axis, a value
+// within fuzz of the last value let through is dropped, which mirrors the
+// kernel's fuzz handling for drivers and consumers that do not apply it.
+// Values that do pass may additionally be smoothed with an exponential
+// moving average. Events of axes that are not configured, and of other
+// event types, pass through unchanged.
+//
+// Multitouch axes are filtered per slot: the filter follows ABS_MT_SLOT
+// and keeps separate state for each contact, which is reset when the
+// slot's ABS_MT_TRACKING_ID changes. ABS_MT_SLOT and ABS_MT_TRACKING_ID
+// themselves are never filtered.
+type AbsFilter struct {
+	axes map[uint16]*abs_axis_filter
+	slot int32 // current multitouch slot
+}
+
+type abs_axis_filter struct {
+	fuzz  int32
+	alpha float64                    // weight of a new value in the moving average
+	last  int32                      // last value let through
+	seen  bool                       // whether last is valid
+	slots map[int32]*abs_axis_filter // state per slot of a multitouch axis
+}
+
+// Create an AbsFilter with no axes configured.
+func NewAbsFilter() *AbsFilter {
+	return &AbsFilter{axes: make(map[uint16]*abs_axis_filter)}
+}
+
+// Configure filtering for the absolute axis code. Changes of at most fuzz
+// are suppressed. Smoothing is the weight, in (0, 1], given to a new value
+// in the moving average; 1 (or any value outside the range) disables
+// smoothing. ABS_MT_SLOT and ABS_MT_TRACKING_ID are ignored.
+func (f *AbsFilter) SetAxis(code int, fuzz int32, smoothing float64) {
+	if code == ABS_MT_SLOT || code == ABS_MT_TRACKING_ID {
+		return
+	}
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 1
+	}
+	axis := &abs_axis_filter{fuzz: fuzz, alpha: smoothing}
+	if is_mt_slot_axis(code) {
+		axis.slots = make(map[int32]*abs_axis_filter)
+	}
+	f.axes[uint16(code)] = axis
+}
+
+// Filter a slice of events (see EventTransformer), returning the events
+// that should be passed on. The input slice is not modified.
+func (f *AbsFilter) Transform(events []InputEvent) []InputEvent {
+	out := make([]InputEvent, 0, len(events))
+
+	for _, ev := range events {
+		if ev.Type == EV_ABS {
+			switch ev.Code {
+			case ABS_MT_SLOT:
+				f.slot = ev.Value
+			case ABS_MT_TRACKING_ID:
+				f.reset_slot(f.slot)
+			default:
+				if axis := f.axis(ev.Code); axis != nil {
+					value, pass := axis.update(ev.Value)
+					if !pass {
+						continue
+					}
+					ev.Value = value
+				}
+			}
+		}
+		out = append(out, ev)
+	}
+
+	return out
+}
+
+// The filter state for code, for the current slot if it is a multitouch
+// axis, or nil if code is not configured.
+func (f *AbsFilter) axis(code uint16) *abs_axis_filter {
+	axis, ok := f.axes[code]
+	if !ok || axis.slots == nil {
+		return axis
+	}
+	state, ok := axis.slots[f.slot]
+	if !ok {
+		state = &abs_axis_filter{fuzz: axis.fuzz, alpha: axis.alpha}
+		axis.slots[f.slot] = state
+	}
+	return state
+}
+
+// Forget the state of slot so that the next contact starts afresh.
+func (f *AbsFilter) reset_slot(slot int32) {
+	for _, axis := range f.axes {
+		if axis.slots != nil {
+			delete(axis.slots, slot)
+		}
+	}
+}
+
+func (axis *abs_axis_filter) update(value int32) (int32, bool) {
+	if axis.seen {
+		delta := int64(value) - int64(axis.last)
+		if delta <= int64(axis.fuzz) && -delta <= int64(axis.fuzz) {
+			return 0, false
+		}
+		if axis.alpha < 1 {
+			avg := axis.alpha*float64(value) + (1-axis.alpha)*float64(axis.last)
+			value = int32(math.Round(avg))
+		}
+	}
+
+	axis.last, axis.seen = value, true
+	return value, true
+}
+
+// Whether code is a per-slot multitouch axis (type B protocol).
+func is_mt_slot_axis(code int) bool {
+	return code >= ABS_MT_TOUCH_MAJOR && code <= ABS_MT_TOOL_Y
+}
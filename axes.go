@@ -0,0 +1,93 @@
+package evdev
+
+// The role of an absolute axis on a joystick or gamepad.
+type AxisRole int
+
+const (
+	AxisUnknown AxisRole = iota
+	LeftStickX
+	LeftStickY
+	RightStickX
+	RightStickY
+	HatX
+	HatY
+	TriggerLeft
+	TriggerRight
+)
+
+var axis_role_names = [...]string{
+	AxisUnknown:  "unknown",
+	LeftStickX:   "left stick x",
+	LeftStickY:   "left stick y",
+	RightStickX:  "right stick x",
+	RightStickY:  "right stick y",
+	HatX:         "hat x",
+	HatY:         "hat y",
+	TriggerLeft:  "left trigger",
+	TriggerRight: "right trigger",
+}
+
+func (role AxisRole) String() string {
+	if role >= 0 && int(role) < len(axis_role_names) {
+		return axis_role_names[role]
+	}
+	return "unknown"
+}
+
+// Identifies a device model by its USB (or other bus) ids.
+type VendorProduct struct {
+	Vendor, Product uint16
+}
+
+// Axis roles of devices that do not follow the usual conventions, by
+// vendor and product. An entry overrides the roles of the axis codes it
+// lists; ClassifyAxes still assigns the others.
+var AxisLayoutOverrides = map[VendorProduct]map[int]AxisRole{}
+
+// Classify the absolute axis codes of a joystick or gamepad by role,
+// following the Linux gamepad conventions:
+//
+//	ABS_X, ABS_Y            left stick
+//	ABS_RX, ABS_RY          right stick
+//	ABS_Z, ABS_RZ           left and right analog triggers
+//	ABS_HAT0X, ABS_HAT0Y    d-pad or hat
+//
+// Many generic HID gamepads instead put the right stick on ABS_Z and
+// ABS_RZ and the triggers on ABS_BRAKE and ABS_GAS; that layout is assumed
+// when ABS_RX and ABS_RY are missing and ABS_BRAKE or ABS_GAS is present.
+// Codes without a role are not included in the result.
+func ClassifyAxes(codes []int, id VendorProduct) map[int]AxisRole {
+	present := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		present[code] = true
+	}
+
+	roles := map[int]AxisRole{
+		ABS_X:     LeftStickX,
+		ABS_Y:     LeftStickY,
+		ABS_HAT0X: HatX,
+		ABS_HAT0Y: HatY,
+	}
+	if !present[ABS_RX] && !present[ABS_RY] && (present[ABS_BRAKE] || present[ABS_GAS]) {
+		roles[ABS_Z] = RightStickX
+		roles[ABS_RZ] = RightStickY
+		roles[ABS_BRAKE] = TriggerLeft
+		roles[ABS_GAS] = TriggerRight
+	} else {
+		roles[ABS_RX] = RightStickX
+		roles[ABS_RY] = RightStickY
+		roles[ABS_Z] = TriggerLeft
+		roles[ABS_RZ] = TriggerRight
+	}
+	for code, role := range AxisLayoutOverrides[id] {
+		roles[code] = role
+	}
+
+	layout := make(map[int]AxisRole)
+	for code, role := range roles {
+		if present[code] && role != AxisUnknown {
+			layout[code] = role
+		}
+	}
+	return layout
+}
@@ -0,0 +1,181 @@
+// +build linux
+
+package evdev
+
+import (
+	"context"
+	"sync"
+)
+
+// Reads a device once and hands the events to any number of subscribers,
+// e.g. a hotkey engine and a logger sharing one grabbed keyboard. Each
+// subscriber gets its own copy of every batch of events read, through its
+// own buffer. What happens when a subscriber falls behind depends on its
+// OverflowPolicy; with OverflowBlock, a subscriber whose buffer is full
+// holds up delivery to the others until it catches up.
+type Broadcaster struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]bool
+	closed bool  // the read loop has ended
+	err    error // why it ended, see Err
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// A subscriber of a Broadcaster.
+type Subscription struct {
+	// Batches of events in the order read. The channel is closed when the
+	// subscription ends, through Unsubscribe or because the broadcaster
+	// stopped.
+	Events <-chan []InputEvent
+
+	b      *Broadcaster
+	queue  *event_queue
+	filter Filter // nil to receive all events
+}
+
+// Start reading events from dev (with the device's ReadErrorPolicy) and
+// broadcasting them. The broadcaster must be the only reader of dev.
+func NewBroadcaster(dev *InputDevice) *Broadcaster {
+	return new_broadcaster(dev.stream_read)
+}
+
+func new_broadcaster(read func(context.Context) ([]InputEvent, error)) *Broadcaster {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Broadcaster{
+		subs:   make(map[*Subscription]bool),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go b.run(ctx, read)
+	return b
+}
+
+// Add a subscriber that buffers up to buffer batches, using
+// OverflowBlock. Events read before subscribing are not delivered to it.
+func (b *Broadcaster) Subscribe(buffer int) *Subscription {
+	return b.SubscribeWithPolicy(buffer, OverflowBlock)
+}
+
+// Add a subscriber that buffers up to buffer batches and applies policy
+// when the buffer is full.
+func (b *Broadcaster) SubscribeWithPolicy(buffer int, policy OverflowPolicy) *Subscription {
+	return b.SubscribeFiltered(buffer, policy, nil)
+}
+
+// Like SubscribeWithPolicy, but only deliver the events that filter
+// matches. Filtering happens before buffering, so events that are not
+// wanted take no room in the buffer.
+func (b *Broadcaster) SubscribeFiltered(buffer int, policy OverflowPolicy, filter Filter) *Subscription {
+	ch := make(chan []InputEvent)
+	s := &Subscription{Events: ch, b: b, queue: new_event_queue(buffer, policy), filter: filter}
+	go s.deliver(ch)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		s.queue.close()
+	} else {
+		b.subs[s] = true
+	}
+	return s
+}
+
+func (s *Subscription) deliver(ch chan<- []InputEvent) {
+	defer close(ch)
+	for {
+		batch, ok := s.queue.get()
+		if !ok {
+			return
+		}
+		ch <- batch.events
+	}
+}
+
+// Stop delivering events to the subscriber; events that it has not
+// received yet are discarded.
+func (s *Subscription) Unsubscribe() {
+	b := s.b
+	b.mu.Lock()
+	delete(b.subs, s)
+	b.mu.Unlock()
+
+	s.queue.cancel()
+	// Unblock a pending delivery; the channel is closed after it.
+	for range s.Events {
+	}
+}
+
+// Return the number of events dropped by the subscription's
+// OverflowPolicy.
+func (s *Subscription) Dropped() uint64 {
+	return s.queue.dropped_events()
+}
+
+func (b *Broadcaster) run(ctx context.Context, read func(context.Context) ([]InputEvent, error)) {
+	defer close(b.done)
+	for {
+		events, err := read(ctx)
+		if err != nil {
+			b.finish(ctx, err)
+			return
+		}
+
+		b.mu.Lock()
+		subs := make([]*Subscription, 0, len(b.subs))
+		for s := range b.subs {
+			subs = append(subs, s)
+		}
+		b.mu.Unlock()
+
+		for _, s := range subs {
+			if s.filter == nil {
+				s.queue.put(queued_batch{events: append([]InputEvent(nil), events...)})
+			} else if batch := filter_events(s.filter, events); len(batch) > 0 {
+				s.queue.put(queued_batch{events: batch})
+			}
+		}
+	}
+}
+
+func (b *Broadcaster) finish(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ctx.Err() == nil {
+		b.err = err
+	}
+	b.closed = true
+	for s := range b.subs {
+		s.queue.close()
+	}
+	b.subs = nil
+}
+
+// Return the read error that stopped the broadcaster, or nil if it is
+// still running or was stopped by Close.
+func (b *Broadcaster) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// Stop reading and end all subscriptions; events that subscribers have
+// not received yet are discarded. The device is not closed.
+func (b *Broadcaster) Close() error {
+	b.mu.Lock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for s := range b.subs {
+		subs = append(subs, s)
+	}
+	b.mu.Unlock()
+
+	b.cancel()
+	for _, s := range subs {
+		s.queue.cancel() // unblocks the read loop if it waits for room
+		for range s.Events {
+		}
+	}
+	<-b.done
+	return nil
+}
@@ -0,0 +1,77 @@
+package evdev
+
+// Adds lookahead to an EventSource: events can be peeked at without
+// consuming them, and consumed events can be pushed back. This helps
+// consumers that decide what to do with an event based on what follows
+// it, e.g. a multitouch slot tracker looking ahead to the next SYN_REPORT.
+// A BufferedReader is itself an EventSource.
+type BufferedReader struct {
+	src     EventSource
+	pending []InputEvent // read from src (or unread) but not yet returned
+}
+
+// Create a BufferedReader reading from src.
+func NewBufferedReader(src EventSource) *BufferedReader {
+	return &BufferedReader{src: src}
+}
+
+// Return the pending events if any, and otherwise the next events of the
+// source.
+func (r *BufferedReader) Read() ([]InputEvent, error) {
+	if len(r.pending) > 0 {
+		events := r.pending
+		r.pending = nil
+		return events, nil
+	}
+	return r.src.Read()
+}
+
+// Return the next event.
+func (r *BufferedReader) ReadOne() (*InputEvent, error) {
+	if err := r.fill(1); err != nil {
+		return nil, err
+	}
+	ev := r.pending[0]
+	r.pending = r.pending[1:]
+	return &ev, nil
+}
+
+// Return the next event without consuming it.
+func (r *BufferedReader) Peek() (*InputEvent, error) {
+	if err := r.fill(1); err != nil {
+		return nil, err
+	}
+	ev := r.pending[0]
+	return &ev, nil
+}
+
+// Return the events up to and including the next SYN_REPORT without
+// consuming them.
+func (r *BufferedReader) PeekFrame() ([]InputEvent, error) {
+	for frame_end(r.pending) < 0 {
+		if err := r.fill(len(r.pending) + 1); err != nil {
+			return nil, err
+		}
+	}
+	frame := make([]InputEvent, frame_end(r.pending)+1)
+	copy(frame, r.pending)
+	return frame, nil
+}
+
+// Push events back, so that they are returned next, in the given order,
+// ahead of any other pending events.
+func (r *BufferedReader) Unread(events ...InputEvent) {
+	r.pending = append(append([]InputEvent(nil), events...), r.pending...)
+}
+
+// Read from the source until at least n events are pending.
+func (r *BufferedReader) fill(n int) error {
+	for len(r.pending) < n {
+		events, err := r.src.Read()
+		if err != nil {
+			return err
+		}
+		r.pending = append(r.pending, events...)
+	}
+	return nil
+}
@@ -11,6 +11,7 @@ package evdev
  static int _EVIOCGLED(int len) {return EVIOCGLED(len);}
  static int _EVIOCGSND(int len) {return EVIOCGSND(len);}
  static int _EVIOCGSW(int len)  {return EVIOCGSW(len);}
+ static int _EVIOCGMTSLOTS(int len) {return EVIOCGMTSLOTS(len);}
 
  static int _EVIOCGBIT(int ev, int len) {return EVIOCGBIT(ev, len);}
  static int _EVIOCGABS(int abs)    {return EVIOCGABS(abs);}
@@ -29,8 +30,13 @@ const (
 	sizeofInputAbsinfo     = C.sizeof_struct_input_absinfo
 	sizeofInputId          = C.sizeof_struct_input_id
 	sizeofInputKeymapEntry = C.sizeof_struct_input_keymap_entry
+	sizeofInputEvent       = C.sizeof_struct_input_event
 )
 
+// Fail to compile unless eventsize matches the kernel headers.
+var _ [eventsize - sizeofInputEvent]byte
+var _ [sizeofInputEvent - eventsize]byte
+
 const MAX_NAME_SIZE = 256
 
 const (
@@ -51,6 +57,8 @@ const (
 
 	EVIOCGRAB     = C.EVIOCGRAB     // grab/release device
 	EVIOCSCLOCKID = C.EVIOCSCLOCKID // set clockid to be used for timestamps
+	EVIOCSMASK    = C.EVIOCSMASK    // set event mask
+	EVIOCREVOKE   = C.EVIOCREVOKE   // revoke device access
 )
 
 var EVIOCGNAME = C._EVIOCGNAME(MAX_NAME_SIZE) // get device name
@@ -66,6 +74,7 @@ var EVIOCGSW = C._EVIOCGSW(MAX_NAME_SIZE)   // get all switch states
 func EVIOCGBIT(ev, l int) int { return int(C._EVIOCGBIT(C.int(ev), C.int(l))) } // get event bits
 func EVIOCGABS(abs int) int   { return int(C._EVIOCGABS(C.int(abs))) }          // get abs bits
 func EVIOCSABS(abs int) int   { return int(C._EVIOCSABS(C.int(abs))) }          // set abs bits
+func EVIOCGMTSLOTS(l int) int { return int(C._EVIOCGMTSLOTS(C.int(l))) }        // get multitouch slot values
 
 func ioctl(fd uintptr, name uintptr, data unsafe.Pointer) syscall.Errno {
 	_, _, err := syscall.RawSyscall(syscall.SYS_IOCTL, fd, name, uintptr(data))
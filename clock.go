@@ -0,0 +1,18 @@
+package evdev
+
+import "time"
+
+// Source of time for time-dependent features. Everything in the package
+// that needs the current time goes through clk so that tests can swap in
+// a fake clock instead of sleeping.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+var clk clock = systemClock{}
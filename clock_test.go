@@ -0,0 +1,92 @@
+package evdev
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// A manually advanced clock for tests.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	when time.Time
+	c    chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := fakeWaiter{c.now.Add(d), make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- c.now
+	} else {
+		c.waiters = append(c.waiters, w)
+	}
+	return w.c
+}
+
+// Move the clock forward, firing any After channels that became due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if !w.when.After(c.now) {
+			w.c <- c.now
+		} else {
+			pending = append(pending, w)
+		}
+	}
+	c.waiters = pending
+}
+
+// Install a fake clock for the duration of a test.
+func useFakeClock(t *testing.T) *fakeClock {
+	c := newFakeClock()
+	saved := clk
+	clk = c
+	t.Cleanup(func() { clk = saved })
+	return c
+}
+
+func TestFakeClock(t *testing.T) {
+	c := useFakeClock(t)
+	start := clk.Now()
+
+	ch := clk.After(time.Second)
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-ch:
+		t.Fatal("After fired early")
+	default:
+	}
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-ch:
+	default:
+		t.Fatal("After did not fire")
+	}
+
+	if got := clk.Now().Sub(start); got != time.Second {
+		t.Errorf("elapsed %v, want 1s", got)
+	}
+}
@@ -1,4 +1,5 @@
 // +build linux
+
 // Input device event monitor.
 package main
 
@@ -32,8 +33,8 @@ func select_device() (*evdev.InputDevice, error) {
 			lines = append(lines, str)
 		}
 		fmt.Printf("%-3s %-20s %-35s %s\n", "ID", "Device", "Name", "Phys")
-		fmt.Printf(strings.Repeat("-", max) + "\n")
-		fmt.Printf(strings.Join(lines, "\n") + "\n")
+		fmt.Println(strings.Repeat("-", max))
+		fmt.Println(strings.Join(lines, "\n"))
 
 		var choice int
 		choice_max := len(lines) - 1
@@ -118,14 +119,16 @@ func main() {
 		dev.Bustype, dev.Vendor, dev.Product, dev.Version)
 
 	repeat, delay, err := dev.GetRepeatRate()
-	if err != nil {
+	if err != nil && err != evdev.ErrUnsupported {
 		fatalf("Failed to get repeat reate, error: %s\n", err)
 	}
 
 	fmt.Printf("Evdev protocol version: %d\n", dev.EvdevVersion)
 	fmt.Printf("Device name: %s\n", dev.Name)
 	fmt.Printf("Device info: %s\n", info)
-	fmt.Printf("Repeat settings: repeat %d. delay %d\n", repeat, delay)
+	if err == nil {
+		fmt.Printf("Repeat settings: repeat %d. delay %d\n", repeat, delay)
+	}
 	fmt.Printf("Device capabilities:\n")
 
 	for ctype, codes := range dev.Capabilities {
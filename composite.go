@@ -0,0 +1,173 @@
+// +build linux
+
+package evdev
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// The event nodes of one physical device, e.g. the keyboard, consumer
+// control and system control nodes of a USB keyboard.
+type CompositeDevice struct {
+	Devices []*InputDevice
+
+	reads  chan composite_read
+	done   chan struct{}
+	mu     sync.Mutex // protects active
+	active int        // nodes that have not failed
+	closed sync.Once
+}
+
+type composite_read struct {
+	dev    *InputDevice
+	events []InputEvent
+	err    error
+}
+
+// Open the first input device for which match returns true, together with
+// all other event nodes of the same USB device (as found through sysfs).
+// A device that is not on USB is opened on its own.
+func OpenComposite(match func(*InputDevice) bool) (*CompositeDevice, error) {
+	devices, _ := ListInputDevices()
+
+	var first *InputDevice
+	for _, dev := range devices {
+		if match(dev) {
+			first = dev
+			break
+		}
+	}
+	if first == nil {
+		for _, dev := range devices {
+			dev.Close()
+		}
+		return nil, errors.New("no matching input device")
+	}
+
+	members := composite_members(devices, first)
+	for _, dev := range devices {
+		if !contains_device(members, dev) {
+			dev.Close()
+		}
+	}
+	return new_composite(members), nil
+}
+
+// Return first and the devices that share its physical parent.
+func composite_members(devices []*InputDevice, first *InputDevice) []*InputDevice {
+	members := []*InputDevice{first}
+	parent := physical_parent(first.SysfsPath())
+	if parent == "" {
+		return members
+	}
+	for _, dev := range devices {
+		if dev != first && physical_parent(dev.SysfsPath()) == parent {
+			members = append(members, dev)
+		}
+	}
+	return members
+}
+
+// USB device directory names in sysfs: bus-port[.port...], e.g. 1-2.4.
+var usb_device_name = regexp.MustCompile(`^[0-9]+-[0-9]+(\.[0-9]+)*$`)
+
+// Return the sysfs directory of the USB device that the input device
+// directory dir belongs to, or "" if it is not a USB device.
+func physical_parent(dir string) string {
+	path, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return ""
+	}
+	for ; path != "/" && path != "."; path = filepath.Dir(path) {
+		if usb_device_name.MatchString(filepath.Base(path)) {
+			return path
+		}
+	}
+	return ""
+}
+
+func contains_device(devices []*InputDevice, dev *InputDevice) bool {
+	for _, d := range devices {
+		if d == dev {
+			return true
+		}
+	}
+	return false
+}
+
+func new_composite(devices []*InputDevice) *CompositeDevice {
+	c := &CompositeDevice{
+		Devices: devices,
+		reads:   make(chan composite_read),
+		done:    make(chan struct{}),
+		active:  len(devices),
+	}
+	for _, dev := range devices {
+		go c.read_loop(dev)
+	}
+	return c
+}
+
+func (c *CompositeDevice) read_loop(dev *InputDevice) {
+	for {
+		events, err := dev.Read()
+		select {
+		case c.reads <- composite_read{dev, events, err}:
+		case <-c.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read events from whichever node has them first, and return that node
+// along with its events. A node that fails is returned with its error and
+// not read from again. Once all nodes have failed, or the composite device
+// was closed, Read returns io.EOF. Read may be called from several
+// goroutines.
+func (c *CompositeDevice) Read() (*InputDevice, []InputEvent, error) {
+	select {
+	case <-c.done:
+		return nil, nil, io.EOF
+	default:
+	}
+	c.mu.Lock()
+	active := c.active
+	c.mu.Unlock()
+	if active == 0 {
+		return nil, nil, io.EOF
+	}
+
+	select {
+	case r := <-c.reads:
+		if r.err != nil {
+			c.mu.Lock()
+			c.active--
+			c.mu.Unlock()
+		}
+		return r.dev, r.events, r.err
+	case <-c.done:
+		return nil, nil, io.EOF
+	}
+}
+
+// Close all nodes of the composite device. Closing it again does nothing
+// and returns nil.
+func (c *CompositeDevice) Close() error {
+	var first error
+	c.closed.Do(func() {
+		close(c.done)
+		for _, dev := range c.Devices {
+			if err := dev.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	})
+	return first
+}
@@ -4,11 +4,21 @@ package evdev
 
 import (
 	"bytes"
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/npat-efault/poller"
@@ -20,6 +30,7 @@ type InputDevice struct {
 
 	Name string     // device name
 	Phys string     // physical topology of device
+	Uniq string     // unique identifier (e.g. serial number), often empty
 	File *poller.FD // an open file handle to the input device
 
 	Bustype uint16 // bus type identifier
@@ -30,12 +41,39 @@ type InputDevice struct {
 	EvdevVersion int // evdev protocol version
 
 	Capabilities     map[CapabilityType][]CapabilityCode // supported event types and codes.
-	CapabilitiesFlat map[int][]int
+	CapabilitiesFlat map[int][]int                       // the same, as plain type and code numbers
+
+	grabMu   sync.Mutex // protects grabRefs and grabbed
+	grabRefs int        // number of outstanding GrabRef calls
+	grabbed  bool       // whether the device is grabbed
+
+	flags   int   // poller open flags
+	revoked int32 // set atomically by Revoke
+
+	shared event_buffer // reused by ReadShared
+	keymap Keymap       // applied to all events read, see SetKeymap
+	frame  []InputEvent // events read past the last frame, see ReadFrame
+	batch  int          // events per read, see SetBatchSize
+
+	skipped_types []int           // see SkippedCapabilityTypes
+	policy        ReadErrorPolicy // see SetReadErrorPolicy
+	stats         read_stats      // see Stats
 }
 
 // Open an evdev input device.
 func Open(devnode string) (*InputDevice, error) {
-	f, err := poller.Open(devnode, poller.O_RO)
+	return open_device(devnode, poller.O_RO)
+}
+
+// Open an evdev input device for reading and writing. Write access is
+// needed to send events to the device, such as force feedback playback
+// requests or LED changes.
+func OpenReadWrite(devnode string) (*InputDevice, error) {
+	return open_device(devnode, poller.O_RW)
+}
+
+func open_device(devnode string, flags int) (*InputDevice, error) {
+	f, err := poller.Open(devnode, flags)
 	if err != nil {
 		return nil, err
 	}
@@ -43,61 +81,559 @@ func Open(devnode string) (*InputDevice, error) {
 	dev := InputDevice{}
 	dev.Fn = devnode
 	dev.File = f
+	dev.flags = flags
 
 	if err := dev.set_device_info(); err != nil {
+		f.Close()
 		return nil, fmt.Errorf("read device info: %s", err)
 	}
 	if err := dev.set_device_capabilities(); err != nil {
+		f.Close()
 		return nil, fmt.Errorf("read device capabilities: %s", err)
 	}
 
 	return &dev, nil
 }
 
-// Read and return a slice of input events from device.
-func (dev *InputDevice) Read() ([]InputEvent, error) {
-	events := make([]InputEvent, 16)
-	buffer := make([]byte, eventsize*16)
+// Returned by OpenVerified for a device node that opens but is faulted.
+var ErrDeviceFaulted = errors.New("evdev: device faulted")
 
-	_, err := dev.File.Read(buffer)
+// Open the device like Open, and then check without blocking that the fd
+// is in a usable state: a faulted or disconnected node, which reports an
+// error or hangup right away, is closed again and ErrDeviceFaulted is
+// returned. No events are consumed. This costs an extra epoll instance per
+// call, so it is meant for health checks and enumeration tools.
+func OpenVerified(devnode string) (*InputDevice, error) {
+	dev, err := Open(devnode)
 	if err != nil {
-		return events, err
+		return nil, err
+	}
+	if err := verify_fd(dev.File.Sysfd()); err != nil {
+		dev.Close()
+		return nil, err
 	}
+	return dev, nil
+}
 
-	b := bytes.NewBuffer(buffer)
-	err = binary.Read(b, binary.LittleEndian, &events)
+// Poll fd with a zero timeout and fail if it reports an error or hangup.
+func verify_fd(fd int) error {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
 	if err != nil {
-		return events, err
+		return err
 	}
+	defer syscall.Close(epfd)
 
-	// remove trailing structures
-	for i := range events {
-		if events[i].Time.Sec == 0 {
-			events = append(events[:i])
-			break
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		return err
+	}
+
+	events := make([]syscall.EpollEvent, 1)
+	n, err := syscall.EpollWait(epfd, events, 0)
+	if err != nil {
+		return err
+	}
+	if n > 0 && events[0].Events&(syscall.EPOLLERR|syscall.EPOLLHUP) != 0 {
+		return ErrDeviceFaulted
+	}
+	return nil
+}
+
+// Open the device node again, typically after ErrDeviceHangup once the
+// device has come back (e.g. on resume) or after Revoke. Device
+// information and capabilities are read from the new fd before it
+// replaces the old one, so on failure the device keeps its old file. If
+// the device was grabbed, through Grab or GrabRef, the new fd is grabbed
+// too and the grab reference count is kept. Note that events arriving
+// between opening the new fd and grabbing it are also delivered to other
+// clients.
+//
+// Reopen replaces File, so it must not be called while another goroutine
+// reads from the device, e.g. through a Broadcaster, MergeStreams or a
+// CompositeDevice. The Reconnect policy of the streaming methods calls it
+// from the reading goroutine.
+func (dev *InputDevice) Reopen() error {
+	open := func() (*InputDevice, error) { return open_device(dev.Fn, dev.flags) }
+	return dev.reopen(open, (*InputDevice).grab)
+}
+
+// Replace the device's file and information with those of a device
+// returned by open, grabbing it with grab first if dev is grabbed.
+func (dev *InputDevice) reopen(open func() (*InputDevice, error), grab func(*InputDevice) error) error {
+	fresh, err := open()
+	if err != nil {
+		return err
+	}
+
+	dev.grabMu.Lock()
+	defer dev.grabMu.Unlock()
+
+	if dev.grabbed {
+		if err := grab(fresh); err != nil {
+			fresh.Close()
+			return fmt.Errorf("grab reopened device: %s", err)
 		}
 	}
 
+	if dev.File != nil {
+		dev.File.Close()
+	}
+	dev.File = fresh.File
+	dev.Name, dev.Phys, dev.Uniq = fresh.Name, fresh.Phys, fresh.Uniq
+	dev.Bustype, dev.Vendor, dev.Product, dev.Version = fresh.Bustype, fresh.Vendor, fresh.Product, fresh.Version
+	dev.EvdevVersion = fresh.EvdevVersion
+	dev.Capabilities, dev.CapabilitiesFlat = fresh.Capabilities, fresh.CapabilitiesFlat
+	dev.skipped_types = fresh.skipped_types
+	atomic.StoreInt32(&dev.revoked, 0)
+	dev.frame = nil
+	return nil
+}
+
+// Close the input device.
+func (dev *InputDevice) Close() error {
+	if dev.File == nil {
+		return ErrNotOpen
+	}
+	return dev.File.Close()
+}
+
+// Returned by methods that need an open device when the device has no
+// File, e.g. an InputDevice{} that was not obtained through Open.
+var ErrNotOpen = errors.New("evdev: device not open")
+
+// Lock the device's file for an ioctl; see poller.FD.Lock.
+func (dev *InputDevice) lock() error {
+	if dev.File == nil {
+		return ErrNotOpen
+	}
+	return dev.File.Lock()
+}
+
+// Read and return a slice of input events from device. Read blocks only
+// until at least one event is available and then returns whatever the
+// kernel has queued (up to 16 events, see SetBatchSize); it never waits
+// for the buffer to fill up, so there is no separate low-latency mode.
+// The returned slice may be handed back with ReleaseEvents.
+func (dev *InputDevice) Read() ([]InputEvent, error) {
+	if dev.File == nil {
+		return nil, ErrNotOpen
+	}
+	if dev.is_revoked() {
+		return nil, ErrRevoked
+	}
+	events, err := read_events(dev.File, dev.batch_size())
+	return dev.finish_read(events, err)
+}
+
+// Account for the events read and apply the keymap.
+func (dev *InputDevice) finish_read(events []InputEvent, err error) ([]InputEvent, error) {
+	dev.stats.add(events)
+	return dev.keymap.Transform(events), dev.revoked_error(err)
+}
+
+// Like Read, but decode the events into a buffer owned by the device
+// instead of allocating a new slice on every call. The returned slice is
+// only valid until the next call to ReadShared (or to Events, EncodeEvents
+// and WaitForRelease, which read the same way); copy any events that must
+// be kept longer. This suits a loop that consumes each batch before
+// reading the next, and is not safe for concurrent use.
+func (dev *InputDevice) ReadShared() ([]InputEvent, error) {
+	if dev.File == nil {
+		return nil, ErrNotOpen
+	}
+	if dev.is_revoked() {
+		return nil, ErrRevoked
+	}
+	events, err := dev.shared.read(dev.File, dev.batch_size())
+	return dev.finish_read(events, err)
+}
+
+// Read events into the caller's slice instead of allocating, and return
+// the number of events read (at most len(events)). Like Read, it blocks
+// only until at least one event is available. ReadInto reuses the same
+// internal buffer as ReadShared and is not safe for concurrent use.
+func (dev *InputDevice) ReadInto(events []InputEvent) (int, error) {
+	if dev.File == nil {
+		return 0, ErrNotOpen
+	}
+	if dev.is_revoked() {
+		return 0, ErrRevoked
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+	n, err := dev.shared.read_into(dev.File, events)
+	dev.stats.add(events[:n])
+	dev.keymap.Transform(events[:n])
+	return n, dev.revoked_error(err)
+}
+
+// Set the maximum number of events returned by one Read, ReadShared or
+// TryRead. Larger batches take fewer system calls on high-rate devices;
+// a batch size of 1 returns every event on its own. A size below 1
+// restores the default of 16.
+func (dev *InputDevice) SetBatchSize(n int) {
+	dev.batch = n
+}
+
+func (dev *InputDevice) batch_size() int {
+	if dev.batch < 1 {
+		return 16
+	}
+	return dev.batch
+}
+
+// Rewrite the codes of the key events returned by the read methods
+// according to keymap (see Keymap); nil removes the mapping. Unlike
+// changing the kernel's keycode table, this only affects this process's
+// view of the device. Set the keymap before reading, not concurrently
+// with a read.
+func (dev *InputDevice) SetKeymap(keymap map[int]int) {
+	if keymap == nil {
+		dev.keymap = nil
+		return
+	}
+	dev.keymap = make(Keymap, len(keymap))
+	for from, to := range keymap {
+		dev.keymap[from] = to
+	}
+}
+
+// Read at most max events with a single read. Like Read, this blocks until
+// at least one event is available and returns fewer than max events if
+// that is all the kernel has queued.
+func (dev *InputDevice) ReadN(max int) ([]InputEvent, error) {
+	if max < 1 {
+		return nil, fmt.Errorf("invalid event count %d", max)
+	}
+	if dev.File == nil {
+		return nil, ErrNotOpen
+	}
+	if dev.is_revoked() {
+		return nil, ErrRevoked
+	}
+	events, err := read_events(dev.File, max)
+	return dev.finish_read(events, err)
+}
+
+// Read events like Read, but give up with ctx.Err() once ctx is done
+// instead of blocking until events arrive. Cancellation wakes up a blocked
+// read by expiring the read deadline, so the device stays usable and no
+// queued events are lost.
+func (dev *InputDevice) ReadContext(ctx context.Context) ([]InputEvent, error) {
+	var events []InputEvent
+	err := dev.with_context(ctx, func() (err error) {
+		events, err = dev.Read()
+		return err
+	})
 	return events, err
 }
 
+// Read a single event like ReadOne, but give up with ctx.Err() once ctx
+// is done; see ReadContext.
+func (dev *InputDevice) ReadOneContext(ctx context.Context) (*InputEvent, error) {
+	var event *InputEvent
+	err := dev.with_context(ctx, func() (err error) {
+		event, err = dev.ReadOne()
+		return err
+	})
+	return event, err
+}
+
+// Like ReadContext, but read with ReadShared. The events are only valid
+// until the next read.
+func (dev *InputDevice) readContext(ctx context.Context) ([]InputEvent, error) {
+	var events []InputEvent
+	err := dev.with_context(ctx, func() (err error) {
+		events, err = dev.ReadShared()
+		return err
+	})
+	return events, err
+}
+
+// Run the blocking read, and interrupt it by expiring the read deadline
+// once ctx is done. If the read was interrupted, ctx.Err() is returned.
+func (dev *InputDevice) with_context(ctx context.Context, read func() error) error {
+	if dev.File == nil {
+		return ErrNotOpen
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		return read() // never cancelled
+	}
+
+	stop := make(chan struct{})
+	cancelled := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			dev.File.SetReadDeadline(time.Now())
+			cancelled <- true
+		case <-stop:
+			cancelled <- false
+		}
+	}()
+
+	err := read()
+	close(stop)
+	if <-cancelled {
+		dev.File.SetReadDeadline(time.Time{})
+		if err != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
 // Read and return a single input event.
 func (dev *InputDevice) ReadOne() (*InputEvent, error) {
-	event := InputEvent{}
-	buffer := make([]byte, eventsize)
+	if dev.File == nil {
+		return nil, ErrNotOpen
+	}
+	if dev.is_revoked() {
+		return nil, ErrRevoked
+	}
+	event, err := read_one_event(dev.File)
+	if event != nil {
+		dev.stats.add([]InputEvent{*event})
+		dev.keymap.remap(event)
+	}
+	return event, dev.revoked_error(err)
+}
+
+// Read one complete frame of events: everything the kernel reported up to
+// and including the next SYN_REPORT. Events read beyond the end of the
+// frame are kept for the next call. A frame that contains SYN_DROPPED is
+// incomplete, and the state it describes should be re-queried (e.g. with
+// KeyState or AbsInfo). Don't mix ReadFrame with the other read methods,
+// as they don't see the events buffered by ReadFrame.
+func (dev *InputDevice) ReadFrame() ([]InputEvent, error) {
+	for {
+		if i := frame_end(dev.frame); i >= 0 {
+			frame := make([]InputEvent, i+1)
+			copy(frame, dev.frame)
+			dev.frame = append(dev.frame[:0], dev.frame[i+1:]...)
+			return frame, nil
+		}
+		events, err := dev.Read()
+		if err != nil {
+			return nil, err
+		}
+		dev.frame = append(dev.frame, events...)
+		ReleaseEvents(events)
+	}
+}
+
+// Returned by reads that did not complete before the read deadline.
+var ErrTimeout = poller.ErrTimeout
+
+// Set the deadline for the read methods. A read that is blocked when the
+// deadline expires, or that starts after it, fails with ErrTimeout. The
+// zero time disables the deadline. Cancelling a context-aware read (such
+// as ReadContext) also clears the deadline.
+func (dev *InputDevice) SetReadDeadline(t time.Time) error {
+	if dev.File == nil {
+		return ErrNotOpen
+	}
+	return dev.File.SetReadDeadline(t)
+}
+
+// Read events like Read, but wait at most d for them to arrive. If no
+// events arrived in time, ErrTimeout is returned. This lets a single
+// goroutine poll a device in between other periodic work.
+func (dev *InputDevice) ReadTimeout(d time.Duration) ([]InputEvent, error) {
+	if err := dev.SetReadDeadline(time.Now().Add(d)); err != nil {
+		return nil, err
+	}
+	defer dev.File.SetReadDeadline(time.Time{})
+	return dev.Read()
+}
 
-	_, err := dev.File.Read(buffer)
+// Returned by reads on a device after Revoke.
+var ErrRevoked = errors.New("evdev: device access revoked")
+
+// Revoke access to the device through this file descriptor for good
+// (EVIOCREVOKE), e.g. when a compositor hands the device to another
+// session. Afterwards all reads fail with ErrRevoked; the device has to be
+// opened again to regain access.
+func (dev *InputDevice) Revoke() error {
+	if err := dev.lock(); err != nil {
+		return err
+	}
+	defer dev.File.Unlock()
+	sysfd := uintptr(dev.File.Sysfd())
+
+	if errno := ioctl(sysfd, uintptr(EVIOCREVOKE), unsafe.Pointer(nil)); errno != 0 {
+		return errno
+	}
+	atomic.StoreInt32(&dev.revoked, 1)
+	return nil
+}
+
+func (dev *InputDevice) is_revoked() bool {
+	return atomic.LoadInt32(&dev.revoked) != 0
+}
+
+// A read that was blocked while the device was revoked fails with ENODEV
+// (ErrDeviceHangup); report it as ErrRevoked instead.
+func (dev *InputDevice) revoked_error(err error) error {
+	if err == ErrDeviceHangup && dev.is_revoked() {
+		return ErrRevoked
+	}
+	return err
+}
+
+// Read all events that are currently queued, without blocking: reads are
+// repeated until the kernel's buffer is empty, and the events of all of
+// them are returned. When nothing is queued the result is empty.
+func (dev *InputDevice) DrainAll() ([]InputEvent, error) {
+	if dev.File == nil {
+		return nil, ErrNotOpen
+	}
+	if dev.is_revoked() {
+		return nil, ErrRevoked
+	}
+	events, err := drain_events(nonblocking_reader(dev.File.Sysfd()))
+	return dev.finish_read(events, err)
+}
+
+// Discard all events that are currently queued, without blocking, and
+// return how many were discarded. This includes a partial frame buffered
+// by ReadFrame. Call Drain after Grab so that events queued before the
+// grab don't leak into the new session.
+func (dev *InputDevice) Drain() (int, error) {
+	events, err := dev.DrainAll()
 	if err != nil {
-		return &event, err
+		return 0, err
+	}
+	n := len(events) + len(dev.frame)
+	dev.frame = nil
+	return n, nil
+}
+
+// Return statistics of the events read from the device so far, by any of
+// the read methods.
+func (dev *InputDevice) Stats() DeviceStats {
+	return dev.stats.snapshot()
+}
+
+// Returned by TryRead when no events are queued.
+var ErrNoEvents = errors.New("evdev: no events available")
+
+// Read events like Read, but never block: if the kernel has no events
+// queued, return ErrNoEvents at once. Devices are always opened with
+// O_NONBLOCK (blocking reads wait in the poller instead), so no special
+// open mode is needed. This suits loops that poll once per iteration,
+// e.g. once per frame.
+func (dev *InputDevice) TryRead() ([]InputEvent, error) {
+	if dev.File == nil {
+		return nil, ErrNotOpen
+	}
+	if dev.is_revoked() {
+		return nil, ErrRevoked
+	}
+	events, err := read_events(nonblocking_reader(dev.File.Sysfd()), dev.batch_size())
+	if err == syscall.EAGAIN {
+		return nil, ErrNoEvents
+	}
+	return dev.finish_read(events, err)
+}
+
+// Reads directly from a non-blocking file descriptor, bypassing the
+// poller's wait for readiness.
+type nonblocking_reader int
+
+func (fd nonblocking_reader) Read(p []byte) (int, error) {
+	n, err := syscall.Read(int(fd), p)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func drain_events(r io.Reader) ([]InputEvent, error) {
+	var b event_buffer
+	events := make([]InputEvent, 0)
+	for {
+		batch, err := b.read(r, 64)
+		if err == syscall.EAGAIN {
+			return events, nil
+		} else if err != nil {
+			return nil, err
+		}
+		events = append(events, batch...)
+	}
+}
+
+// Recover from a SYN_DROPPED event. The kernel's event buffer overflowed, so
+// every event up to and including the next SYN_REPORT is incomplete and is
+// read and discarded. The returned event types are those of the device
+// that carry state (EV_KEY, EV_ABS, EV_SW, EV_LED, EV_SND), which the
+// caller should now re-query (e.g. with KeyState or AbsInfo).
+func (dev *InputDevice) ResyncAfterDrop() ([]int, error) {
+	if dev.File == nil {
+		return nil, ErrNotOpen
+	}
+	return resync_after_drop(dev.File, dev.Capabilities)
+}
+
+func resync_after_drop(r io.Reader, capabilities map[CapabilityType][]CapabilityCode) ([]int, error) {
+	for {
+		ev, err := read_one_event(r)
+		if err != nil {
+			return nil, err
+		}
+		if ev.Type == EV_SYN && ev.Code == SYN_REPORT {
+			break
+		}
+	}
+
+	stale := make([]int, 0)
+	for _, ctype := range sorted_capability_types(capabilities) {
+		switch ctype.Type {
+		case EV_KEY, EV_ABS, EV_SW, EV_LED, EV_SND:
+			stale = append(stale, ctype.Type)
+		}
 	}
+	return stale, nil
+}
+
+// Root of the proc mount; tests point it at a fake tree.
+var proc_root = "/proc"
 
-	b := bytes.NewBuffer(buffer)
-	err = binary.Read(b, binary.LittleEndian, &event)
+// Report whether another process has the device node open, by scanning
+// /proc/*/fd the way fuser does. This is best-effort: the fds of processes
+// owned by other users can only be inspected as root, so without enough
+// privileges a false result only covers the processes that were readable.
+func (dev *InputDevice) InUse() (bool, error) {
+	return node_in_use(proc_root, resolve_devnode(dev.Fn), os.Getpid())
+}
+
+func node_in_use(proc string, node string, self int) (bool, error) {
+	entries, err := ioutil.ReadDir(proc)
 	if err != nil {
-		return &event, err
+		return false, err
 	}
 
-	return &event, err
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		fddir := filepath.Join(proc, entry.Name(), "fd")
+		fds, err := ioutil.ReadDir(fddir)
+		if err != nil {
+			continue // gone, or not ours to inspect
+		}
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(fddir, fd.Name())); err == nil && target == node {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
 }
 
 // Get a useful description for an input device. Example:
@@ -114,62 +650,157 @@ func (dev *InputDevice) String() string {
 	}
 	evtypes_s := strings.Join(evtypes, ", ")
 
+	fd := -1
+	if dev.File != nil {
+		fd = dev.File.Sysfd()
+	}
+
 	return fmt.Sprintf(
 		"InputDevice %s (fd %d)\n"+
 			"  name %s\n"+
 			"  phys %s\n"+
 			"  bus 0x%04x, vendor 0x%04x, product 0x%04x, version 0x%04x\n"+
 			"  events %s",
-		dev.Fn, dev.File.Sysfd(), dev.Name, dev.Phys, dev.Bustype,
+		dev.Fn, fd, dev.Name, dev.Phys, dev.Bustype,
 		dev.Vendor, dev.Product, dev.Version, evtypes_s)
 }
 
-// Gets the event types and event codes that the input device supports.
-func (dev *InputDevice) set_device_capabilities() error {
-	// Capabilities is a map of supported event types to lists of
-	// events e.g: {1: [272, 273, 274, 275], 2: [0, 1, 6, 8]}
-	// capabilities := make(map[int][]int)
-	capabilities := make(map[CapabilityType][]CapabilityCode)
+// Render the device's capabilities in the layout printed by the evtest
+// utility, so that the two can be compared directly. Example:
+//
+//	Supported events:
+//	  Event type 0 (EV_SYN)
+//	  Event type 1 (EV_KEY)
+//	    Event code 272 (BTN_LEFT)
+func (dev *InputDevice) CapabilitiesEvtestFormat() string {
+	return format_evtest_capabilities(dev.Capabilities)
+}
+
+func format_evtest_capabilities(capabilities map[CapabilityType][]CapabilityCode) string {
+	var b strings.Builder
 
-	evbits := new([(EV_MAX + 1) / 8]byte)
-	codebits := new([(KEY_MAX + 1) / 8]byte)
-	// absbits  := new([6]byte)
+	b.WriteString("Supported events:\n")
+	for _, ctype := range sorted_capability_types(capabilities) {
+		fmt.Fprintf(&b, "  Event type %d (%s)\n", ctype.Type, ctype.Name)
+		if ctype.Type == EV_SYN {
+			continue // evtest does not list the EV_SYN codes
+		}
+		for _, code := range sorted_capability_codes(capabilities[ctype]) {
+			fmt.Fprintf(&b, "    Event code %d (%s)\n", code.Code, code.Name)
+		}
+	}
 
-	if err := dev.File.Lock(); err != nil {
+	return b.String()
+}
+
+// Return the capability types of a capability map ordered by type.
+func sorted_capability_types(capabilities map[CapabilityType][]CapabilityCode) []CapabilityType {
+	types := make([]CapabilityType, 0, len(capabilities))
+	for ctype := range capabilities {
+		types = append(types, ctype)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Type < types[j].Type })
+	return types
+}
+
+// Return a copy of codes ordered by code.
+func sorted_capability_codes(codes []CapabilityCode) []CapabilityCode {
+	sorted := append([]CapabilityCode(nil), codes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Code < sorted[j].Code })
+	return sorted
+}
+
+// Gets the event types and event codes that the input device supports.
+func (dev *InputDevice) set_device_capabilities() error {
+	if err := dev.lock(); err != nil {
 		return err
 	}
 	defer dev.File.Unlock()
 	sysfd := uintptr(dev.File.Sysfd())
 
-	errno := ioctl(sysfd, uintptr(EVIOCGBIT(0, EV_MAX)), unsafe.Pointer(evbits))
-	if errno != 0 {
-		return errno
+	capabilities, skipped, err := scan_capabilities(func(evtype int, bits []byte) error {
+		if errno := ioctl(sysfd, uintptr(EVIOCGBIT(evtype, len(bits))), unsafe.Pointer(&bits[0])); errno != 0 {
+			return errno
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	// Build a map of the device's capabilities
-	for evtype := 0; evtype < EV_MAX; evtype++ {
-		if evbits[evtype/8]&(1<<uint(evtype%8)) != 0 {
-			eventcodes := make([]CapabilityCode, 0)
+	dev.skipped_types = skipped
+	dev.Capabilities = capabilities
+	dev.CapabilitiesFlat = flatten_capabilities(capabilities)
+	return nil
+}
 
-			if errno = ioctl(sysfd, uintptr(EVIOCGBIT(evtype, KEY_MAX)), unsafe.Pointer(codebits)); errno != 0 {
-				return errno
-			}
+// Convert a capability map to plain event types and codes, with the codes
+// of each type in ascending order.
+func flatten_capabilities(capabilities map[CapabilityType][]CapabilityCode) map[int][]int {
+	flat := make(map[int][]int, len(capabilities))
+	for ctype, ccodes := range capabilities {
+		codes := make([]int, 0, len(ccodes))
+		for _, c := range sorted_capability_codes(ccodes) {
+			codes = append(codes, c.Code)
+		}
+		flat[ctype.Type] = codes
+	}
+	return flat
+}
 
-			for evcode := 0; evcode < KEY_MAX; evcode++ {
-				if codebits[evcode/8]&(1<<uint(evcode%8)) != 0 {
-					c := CapabilityCode{evcode, ByEventType[evtype][evcode]}
-					eventcodes = append(eventcodes, c)
-				}
+// Build a capability map from the event type bitmap (evtype 0) and the
+// per-type code bitmaps, fetched by get_bits. Each bitmap buffer is sized
+// for the highest code of its type only, and that size is what get_bits is
+// expected to pass on as the EVIOCGBIT length. Only a failure to get the
+// event type bitmap is an error; event types whose code bitmap cannot be
+// read are left out of the map and returned as skipped.
+func scan_capabilities(get_bits func(evtype int, bits []byte) error) (map[CapabilityType][]CapabilityCode, []int, error) {
+	// Capabilities is a map of supported event types to lists of
+	// events e.g: {1: [272, 273, 274, 275], 2: [0, 1, 6, 8]}
+	capabilities := make(map[CapabilityType][]CapabilityCode)
+
+	skipped := make([]int, 0)
+
+	evbits := make([]byte, bitmap_size(EV_MAX))
+	if err := get_bits(0, evbits); err != nil {
+		return nil, nil, err
+	}
+
+	// Build a map of the device's capabilities
+	for _, evtype := range bits_to_codes(evbits, EV_MAX) {
+		eventcodes := make([]CapabilityCode, 0)
+
+		if max := MaxCode(evtype); max >= 0 {
+			codebits := make([]byte, bitmap_size(max))
+			if err := get_bits(evtype, codebits); err != nil {
+				skipped = append(skipped, evtype)
+				continue
 			}
 
-			// capabilities[EV_KEY] = [KEY_A, KEY_B, KEY_C, ...]
-			key := CapabilityType{evtype, EV[evtype]}
-			capabilities[key] = eventcodes
+			for _, evcode := range bits_to_codes(codebits, max) {
+				c := CapabilityCode{evcode, CodeName(evtype, evcode)}
+				eventcodes = append(eventcodes, c)
+			}
 		}
+
+		// capabilities[EV_KEY] = [KEY_A, KEY_B, KEY_C, ...]
+		key := CapabilityType{evtype, TypeName(evtype)}
+		capabilities[key] = eventcodes
 	}
 
-	dev.Capabilities = capabilities
-	return nil
+	return capabilities, skipped, nil
+}
+
+// Return the event types that the device reports but whose codes could
+// not be read when the device was opened. They are missing from
+// Capabilities, which is otherwise complete.
+func (dev *InputDevice) SkippedCapabilityTypes() []int {
+	return append([]int(nil), dev.skipped_types...)
+}
+
+// Return the number of bytes in a bitmap holding bits 0 to max.
+func bitmap_size(max int) int {
+	return max/8 + 1
 }
 
 // An all-in-one function for describing an input device.
@@ -178,8 +809,9 @@ func (dev *InputDevice) set_device_info() error {
 
 	name := new([MAX_NAME_SIZE]byte)
 	phys := new([MAX_NAME_SIZE]byte)
+	uniq := new([MAX_NAME_SIZE]byte)
 
-	if err := dev.File.Lock(); err != nil {
+	if err := dev.lock(); err != nil {
 		return err
 	}
 	defer dev.File.Unlock()
@@ -190,17 +822,19 @@ func (dev *InputDevice) set_device_info() error {
 		return errno
 	}
 
-	if errno = ioctl(sysfd, uintptr(EVIOCGNAME), unsafe.Pointer(name)); errno != 0 {
-		return errno
-	}
+	// the name and unique identifier fall back to sysfs below
+	ioctl(sysfd, uintptr(EVIOCGNAME), unsafe.Pointer(name))
 
 	// it's ok if the topology info is not available
-	if errno = ioctl(sysfd, uintptr(EVIOCGPHYS), unsafe.Pointer(phys)); errno != 0 {
-		return errno
-	}
+	ioctl(sysfd, uintptr(EVIOCGPHYS), unsafe.Pointer(phys))
+
+	// most devices have no unique identifier
+	ioctl(sysfd, uintptr(EVIOCGUNIQ), unsafe.Pointer(uniq))
 
 	dev.Name = bytes_to_string(name)
 	dev.Phys = bytes_to_string(phys)
+	dev.Uniq = bytes_to_string(uniq)
+	dev.set_sysfs_info()
 
 	dev.Vendor = info.vendor
 	dev.Bustype = info.bustype
@@ -218,46 +852,418 @@ func (dev *InputDevice) set_device_info() error {
 	return nil
 }
 
+// Returned when a device does not support the requested operation.
+var ErrUnsupported = errors.New("evdev: operation not supported by device")
+
 // Get repeat rate and delay.
 // Repeat rate is in characters per second. Delay is the amount of time in
 // milliseconds that a key must be depressed before it will start to repeat.
+//
+// Only devices with the EV_REP capability (typically keyboards) have
+// repeat settings. For other devices, including many virtual ones,
+// ErrUnsupported is returned.
 func (dev *InputDevice) GetRepeatRate() (repeat, delay uint, err error) {
-	if err = dev.File.Lock(); err == nil {
+	if err = dev.lock(); err != nil {
 		return
 	}
 	defer dev.File.Unlock()
 	sysfd := uintptr(dev.File.Sysfd())
 
-	var t [2]uint
+	var t [2]uint32
 	if errno := ioctl(sysfd, uintptr(EVIOCGREP), unsafe.Pointer(&t)); errno != 0 {
-		err = errno
+		err = repeat_error(errno)
 		return
 	}
 
-	repeat, delay = t[0], t[1]
+	repeat, delay = uint(t[0]), uint(t[1])
 	return
 }
 
-// Set repeat rate and delay.
+// Set repeat rate and delay. ErrUnsupported is returned for devices
+// without repeat settings, see GetRepeatRate.
 func (dev *InputDevice) SetRepeatRate(repeat, delay uint) error {
-	if err := dev.File.Lock(); err == nil {
+	if err := dev.lock(); err != nil {
 		return err
 	}
 	defer dev.File.Unlock()
 	sysfd := uintptr(dev.File.Sysfd())
 
-	t := [2]uint{repeat, delay}
+	t := [2]uint32{uint32(repeat), uint32(delay)}
 	if errno := ioctl(sysfd, uintptr(EVIOCSREP), unsafe.Pointer(&t)); errno != 0 {
+		return repeat_error(errno)
+	}
+	return nil
+}
+
+// Set the autorepeat delay and period (in milliseconds) by writing
+// EV_REP REP_DELAY and REP_PERIOD events followed by a SYN_REPORT to the
+// device, which the kernel applies like the EVIOCSREP ioctl used by
+// SetRepeatRate. This needs a device opened with OpenReadWrite that has
+// the EV_REP capability; ErrUnsupported is returned for other devices.
+func (dev *InputDevice) SetRepeatViaEvents(delayMs, periodMs int) error {
+	if dev.File == nil {
+		return ErrNotOpen
+	}
+	if !has_capability_type(dev.Capabilities, EV_REP) {
+		return ErrUnsupported
+	}
+	if delayMs < 0 || periodMs < 0 {
+		return fmt.Errorf("invalid repeat delay %d or period %d", delayMs, periodMs)
+	}
+	return write_events(dev.File, repeat_events(delayMs, periodMs))
+}
+
+func repeat_events(delayMs, periodMs int) []InputEvent {
+	return []InputEvent{
+		{Type: EV_REP, Code: REP_DELAY, Value: int32(delayMs)},
+		{Type: EV_REP, Code: REP_PERIOD, Value: int32(periodMs)},
+		{Type: EV_SYN, Code: SYN_REPORT},
+	}
+}
+
+// The kernel answers repeat ioctls with ENOSYS on devices without EV_REP;
+// ENOTTY and EINVAL come from drivers that do not implement them at all.
+func repeat_error(errno syscall.Errno) error {
+	switch errno {
+	case syscall.ENOSYS, syscall.ENOTTY, syscall.EINVAL:
+		return ErrUnsupported
+	}
+	return errno
+}
+
+// Return the codes of all keys and buttons that are currently held down,
+// as reported by the kernel.
+func (dev *InputDevice) KeyState() ([]int, error) {
+	if err := dev.lock(); err != nil {
+		return nil, err
+	}
+	defer dev.File.Unlock()
+	sysfd := uintptr(dev.File.Sysfd())
+
+	state := new([MAX_NAME_SIZE]byte)
+	if errno := ioctl(sysfd, uintptr(EVIOCGKEY), unsafe.Pointer(state)); errno != 0 {
+		return nil, errno
+	}
+
+	return bits_to_codes(state[:], KEY_MAX), nil
+}
+
+// Block until all of the given keys are released, or until ctx is done.
+// The starting state comes from KeyState, so keys that are already up need
+// no event and WaitForRelease returns at once if none of them are held.
+// A key that is pressed again while waiting has to be released again.
+// WaitForRelease reads from the device to follow the keys, and discards
+// every event it reads, including those of other keys; events read
+// while waiting are not returned by later reads.
+func (dev *InputDevice) WaitForRelease(ctx context.Context, codes ...int) error {
+	state, err := dev.KeyState()
+	if err != nil {
+		return err
+	}
+	return dev.wait_for_release(ctx, held_keys(state, codes))
+}
+
+func (dev *InputDevice) wait_for_release(ctx context.Context, held map[int]bool) error {
+	for !all_released(held) {
+		events, err := dev.stream_read(ctx)
+		if err != nil {
+			return err
+		}
+		track_keys(held, events)
+	}
+	return nil
+}
+
+// Return the held state of each of codes, given the held keys in state.
+func held_keys(state []int, codes []int) map[int]bool {
+	held := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		held[code] = false
+	}
+	for _, code := range state {
+		if _, ok := held[code]; ok {
+			held[code] = true
+		}
+	}
+	return held
+}
+
+// Update the held state of the keys in held from events. Keys not in held
+// are ignored; autorepeat events count as held.
+func track_keys(held map[int]bool, events []InputEvent) {
+	for _, ev := range events {
+		if ev.Type != EV_KEY {
+			continue
+		}
+		if _, ok := held[int(ev.Code)]; ok {
+			held[int(ev.Code)] = ev.Value != int32(KeyUp)
+		}
+	}
+}
+
+func all_released(held map[int]bool) bool {
+	for _, down := range held {
+		if down {
+			return false
+		}
+	}
+	return true
+}
+
+// Argument of EVIOCSMASK (struct input_mask).
+type event_mask struct {
+	evtype     uint32
+	codes_size uint32
+	codes_ptr  uint64
+}
+
+// Install an event mask so that the kernel only delivers events of evtype
+// with one of the given codes to this file descriptor; other events of
+// evtype are dropped before they are queued. For evtype EV_SYN the codes
+// are event types, masking whole types instead. Every code has to be among
+// the device's capabilities. Calling it without codes masks evtype
+// entirely. Kernels before 4.4 report ErrUnsupported.
+func (dev *InputDevice) SetEventMaskCodes(evtype int, codes ...int) error {
+	if dev.File == nil {
+		return ErrNotOpen
+	}
+	if err := check_mask_codes(dev.Capabilities, evtype, codes); err != nil {
+		return err
+	}
+	bits, err := event_mask_bits(evtype, codes)
+	if err != nil {
+		return err
+	}
+
+	if err := dev.lock(); err != nil {
+		return err
+	}
+	defer dev.File.Unlock()
+	sysfd := uintptr(dev.File.Sysfd())
+
+	mask := event_mask{
+		evtype:     uint32(evtype),
+		codes_size: uint32(len(bits)),
+		codes_ptr:  uint64(uintptr(unsafe.Pointer(&bits[0]))),
+	}
+	errno := ioctl(sysfd, uintptr(EVIOCSMASK), unsafe.Pointer(&mask))
+	runtime.KeepAlive(bits)
+	if errno == syscall.ENOTTY {
+		return ErrUnsupported
+	} else if errno != 0 {
 		return errno
 	}
 	return nil
 }
 
+// Check that codes of evtype are all among capabilities. For EV_SYN the
+// codes are event types.
+func check_mask_codes(capabilities map[CapabilityType][]CapabilityCode, evtype int, codes []int) error {
+	supported := make(map[int]bool)
+	for ctype, ccodes := range capabilities {
+		if evtype == EV_SYN {
+			supported[ctype.Type] = true
+		} else if ctype.Type == evtype {
+			for _, c := range ccodes {
+				supported[c.Code] = true
+			}
+		}
+	}
+
+	if evtype != EV_SYN && !has_capability_type(capabilities, evtype) {
+		return fmt.Errorf("device does not support %s", TypeName(evtype))
+	}
+	for _, code := range codes {
+		if !supported[code] {
+			if evtype == EV_SYN {
+				return fmt.Errorf("device does not support %s", TypeName(code))
+			}
+			return fmt.Errorf("device does not support %s", CodeName(evtype, code))
+		}
+	}
+	return nil
+}
+
+func has_capability_type(capabilities map[CapabilityType][]CapabilityCode, evtype int) bool {
+	for ctype := range capabilities {
+		if ctype.Type == evtype {
+			return true
+		}
+	}
+	return false
+}
+
+// Build the EVIOCSMASK bitmap of evtype with the bits of codes set.
+func event_mask_bits(evtype int, codes []int) ([]byte, error) {
+	max := MaxCode(evtype)
+	if evtype == EV_SYN {
+		max = EV_MAX
+	}
+	if max < 0 {
+		return nil, fmt.Errorf("%s has no codes to mask", TypeName(evtype))
+	}
+
+	bits := make([]byte, bitmap_size(max))
+	for _, code := range codes {
+		if code < 0 || code > max {
+			return nil, fmt.Errorf("code %d out of range for %s", code, TypeName(evtype))
+		}
+		bits[code/8] |= 1 << uint(code%8)
+	}
+	return bits, nil
+}
+
+// Synthesize a key press event for every key currently held down,
+// followed by a SYN_REPORT. Keys that were already held when the device
+// was grabbed never produce a press event of their own; feeding these
+// events to the application brings its key state in line with reality.
+// They are meant for state reconciliation only and do not represent real
+// input.
+func (dev *InputDevice) HeldKeyEvents() ([]InputEvent, error) {
+	codes, err := dev.KeyState()
+	if err != nil {
+		return nil, err
+	}
+	return key_press_events(codes, syscall.NsecToTimeval(clk.Now().UnixNano())), nil
+}
+
+func key_press_events(codes []int, tv syscall.Timeval) []InputEvent {
+	if len(codes) == 0 {
+		return nil
+	}
+
+	events := make([]InputEvent, 0, len(codes)+1)
+	for _, code := range codes {
+		events = append(events, InputEvent{Time: tv, Type: EV_KEY, Code: uint16(code), Value: int32(KeyDown)})
+	}
+	return append(events, InputEvent{Time: tv, Type: EV_SYN, Code: SYN_REPORT})
+}
+
+// Get the parameters (value, range, fuzz, ...) of an absolute axis.
+func (dev *InputDevice) AbsInfo(code int) (AbsInfo, error) {
+	info := AbsInfo{}
+
+	if err := dev.lock(); err != nil {
+		return info, err
+	}
+	defer dev.File.Unlock()
+	sysfd := uintptr(dev.File.Sysfd())
+
+	if errno := ioctl(sysfd, uintptr(EVIOCGABS(code)), unsafe.Pointer(&info)); errno != 0 {
+		return info, errno
+	}
+	return info, nil
+}
+
+// Return the current values of a multitouch axis (e.g. ABS_MT_POSITION_X)
+// for each of the first count slots (EVIOCGMTSLOTS). The number of slots
+// of a device is AbsInfo(ABS_MT_SLOT).Maximum() + 1.
+func (dev *InputDevice) MTSlots(code, count int) ([]int32, error) {
+	if err := dev.lock(); err != nil {
+		return nil, err
+	}
+	defer dev.File.Unlock()
+	sysfd := uintptr(dev.File.Sysfd())
+
+	// The request is the axis code followed by room for the values.
+	buf := make([]int32, count+1)
+	buf[0] = int32(code)
+	if errno := ioctl(sysfd, uintptr(EVIOCGMTSLOTS(4*len(buf))), unsafe.Pointer(&buf[0])); errno != 0 {
+		return nil, errno
+	}
+	return buf[1:], nil
+}
+
+// Create an AbsFilter covering every absolute axis of the device, using
+// the fuzz reported for each axis as its jitter threshold. ABS_MT_SLOT
+// and ABS_MT_TRACKING_ID are left out. See AbsFilter.SetAxis for the
+// meaning of smoothing.
+func NewAbsFilterFromDevice(dev *InputDevice, smoothing float64) (*AbsFilter, error) {
+	f := NewAbsFilter()
+
+	for ctype, codes := range dev.Capabilities {
+		if ctype.Type != EV_ABS {
+			continue
+		}
+		for _, code := range codes {
+			if code.Code == ABS_MT_SLOT || code.Code == ABS_MT_TRACKING_ID {
+				continue
+			}
+			info, err := dev.AbsInfo(code.Code)
+			if err != nil {
+				return nil, err
+			}
+			f.SetAxis(code.Code, info.fuzz, smoothing)
+		}
+	}
+
+	return f, nil
+}
+
+// An absolute axis of a device, with its resolved name and current
+// AbsInfo.
+type AxisInfo struct {
+	Code int    // ABS_* code
+	Name string // name of the code, e.g. "ABS_X"
+	Info AbsInfo
+}
+
+// Describe every absolute axis of the device, sorted by code.
+func (dev *InputDevice) AbsAxes() ([]AxisInfo, error) {
+	return abs_axes(dev.Capabilities, dev.AbsInfo)
+}
+
+func abs_axes(capabilities map[CapabilityType][]CapabilityCode, abs_info func(code int) (AbsInfo, error)) ([]AxisInfo, error) {
+	axes := make([]AxisInfo, 0)
+	for ctype, codes := range capabilities {
+		if ctype.Type != EV_ABS {
+			continue
+		}
+		for _, c := range sorted_capability_codes(codes) {
+			info, err := abs_info(c.Code)
+			if err != nil {
+				return nil, err
+			}
+			axes = append(axes, AxisInfo{c.Code, CodeName(EV_ABS, c.Code), info})
+		}
+	}
+	return axes, nil
+}
+
+// Classify the absolute axes of the device by role (see ClassifyAxes).
+func (dev *InputDevice) AxisLayout() map[int]AxisRole {
+	codes := make([]int, 0)
+	for ctype, ccodes := range dev.Capabilities {
+		if ctype.Type != EV_ABS {
+			continue
+		}
+		for _, c := range ccodes {
+			codes = append(codes, c.Code)
+		}
+	}
+	return ClassifyAxes(codes, VendorProduct{dev.Vendor, dev.Product})
+}
+
 // Enable exclusive listening of the device. This is useful if you want to
 // capture all events from a device, like a macro pad, keyboard, or gaming
 // mouse.
 func (dev *InputDevice) Grab() error {
-	if err := dev.File.Lock(); err != nil {
+	return dev.grab_with((*InputDevice).grab)
+}
+
+func (dev *InputDevice) grab_with(grab func(*InputDevice) error) error {
+	dev.grabMu.Lock()
+	defer dev.grabMu.Unlock()
+
+	if err := grab(dev); err != nil {
+		return err
+	}
+	dev.grabbed = true
+	return nil
+}
+
+func (dev *InputDevice) grab() error {
+	if err := dev.lock(); err != nil {
 		return err
 	}
 	defer dev.File.Unlock()
@@ -271,9 +1277,67 @@ func (dev *InputDevice) Grab() error {
 	return nil
 }
 
-// Disable exclusive listening of the device.
+// Returned by SafeGrab when the device is already grabbed.
+var ErrAlreadyGrabbed = errors.New("evdev: device is already grabbed")
+
+// Like Grab, but report ErrAlreadyGrabbed if the device is already grabbed
+// instead of an opaque EBUSY. Under a compositor that manages devices
+// through systemd-logind the compositor usually holds the grab; this lets
+// session-aware tools detect that and decide whether to proceed. Note that
+// the kernel also reports EBUSY if this very fd already holds the grab.
+func (dev *InputDevice) SafeGrab() error {
+	err := dev.Grab()
+	if err == syscall.EBUSY {
+		return ErrAlreadyGrabbed
+	}
+	return err
+}
+
+// Check whether the device can be grabbed right now by briefly grabbing
+// and releasing it. If another client holds the grab, false and
+// ErrAlreadyGrabbed are returned; other failures are returned as is. A
+// device already grabbed through this InputDevice reports true.
+//
+// Contrary to common belief, EVIOCGRAB does not need write access: a
+// read-only Open is enough to grab. Write access (OpenReadWrite) is only
+// needed to send events to the device.
+func (dev *InputDevice) CanGrab() (bool, error) {
+	dev.grabMu.Lock()
+	defer dev.grabMu.Unlock()
+
+	if dev.grabbed {
+		return true, nil
+	}
+
+	switch err := dev.grab(); err {
+	case nil:
+		return true, dev.release()
+	case syscall.EBUSY:
+		return false, ErrAlreadyGrabbed
+	default:
+		return false, err
+	}
+}
+
+// Disable exclusive listening of the device. Any references taken by
+// GrabRef are dropped along with the grab.
 func (dev *InputDevice) Release() error {
-	if err := dev.File.Lock(); err != nil {
+	return dev.release_with((*InputDevice).release)
+}
+
+func (dev *InputDevice) release_with(release func(*InputDevice) error) error {
+	dev.grabMu.Lock()
+	defer dev.grabMu.Unlock()
+
+	if err := release(dev); err != nil {
+		return err
+	}
+	dev.grabbed, dev.grabRefs = false, 0
+	return nil
+}
+
+func (dev *InputDevice) release() error {
+	if err := dev.lock(); err != nil {
 		return err
 	}
 	defer dev.File.Unlock()
@@ -285,16 +1349,83 @@ func (dev *InputDevice) Release() error {
 	return nil
 }
 
+// Reference counted variant of Grab. The device is grabbed on the first
+// call, unless it is already grabbed, and subsequent calls only increment
+// the reference count. This allows independent components to share a
+// grabbed device without one component's release undoing another's grab.
+func (dev *InputDevice) GrabRef() error {
+	return dev.grab_ref_with((*InputDevice).grab)
+}
+
+func (dev *InputDevice) grab_ref_with(grab func(*InputDevice) error) error {
+	dev.grabMu.Lock()
+	defer dev.grabMu.Unlock()
+
+	if !dev.grabbed {
+		if err := grab(dev); err != nil {
+			return err
+		}
+		dev.grabbed = true
+	}
+	dev.grabRefs++
+	return nil
+}
+
+// Decrement the grab reference count taken by GrabRef. The device is
+// released when the count reaches zero. Calling ReleaseRef without a
+// matching GrabRef, or after Release, is a no-op.
+func (dev *InputDevice) ReleaseRef() error {
+	return dev.release_ref_with((*InputDevice).release)
+}
+
+func (dev *InputDevice) release_ref_with(release func(*InputDevice) error) error {
+	dev.grabMu.Lock()
+	defer dev.grabMu.Unlock()
+
+	if dev.grabRefs == 0 {
+		return nil
+	}
+	if dev.grabRefs == 1 {
+		if err := release(dev); err != nil {
+			return err
+		}
+		dev.grabbed = false
+	}
+	dev.grabRefs--
+	return nil
+}
+
+// Return the number of outstanding GrabRef calls (useful for debugging).
+func (dev *InputDevice) GrabCount() int {
+	dev.grabMu.Lock()
+	defer dev.grabMu.Unlock()
+	return dev.grabRefs
+}
+
 type CapabilityType struct {
 	Type int
 	Name string
 }
 
+// Return all event types known to the package, independent of any device,
+// sorted by number: EV_SYN, EV_KEY, EV_REL, ... EV_FF_STATUS. EV_MAX and
+// EV_VERSION are not event types and are left out.
+func SupportedEventTypes() []CapabilityType {
+	types := make([]CapabilityType, 0)
+	for evtype := 0; evtype < EV_MAX; evtype++ {
+		if name, ok := EV[evtype]; ok {
+			types = append(types, CapabilityType{evtype, name})
+		}
+	}
+	return types
+}
+
 type CapabilityCode struct {
 	Code int
 	Name string
 }
 
+// Corresponds to the input_absinfo struct.
 type AbsInfo struct {
 	value      int32
 	minimum    int32
@@ -304,6 +1435,25 @@ type AbsInfo struct {
 	resolution int32
 }
 
+// Create an AbsInfo describing an axis, e.g. for configuring a virtual
+// device. The current value starts out as zero.
+func NewAbsInfo(min, max, fuzz, flat, resolution int32) AbsInfo {
+	return AbsInfo{
+		minimum:    min,
+		maximum:    max,
+		fuzz:       fuzz,
+		flat:       flat,
+		resolution: resolution,
+	}
+}
+
+func (info AbsInfo) Value() int32      { return info.value }      // latest reported value
+func (info AbsInfo) Minimum() int32    { return info.minimum }    // minimum value of the axis
+func (info AbsInfo) Maximum() int32    { return info.maximum }    // maximum value of the axis
+func (info AbsInfo) Fuzz() int32       { return info.fuzz }       // noise threshold
+func (info AbsInfo) Flat() int32       { return info.flat }       // dead zone around the center
+func (info AbsInfo) Resolution() int32 { return info.resolution } // units per mm (or radian)
+
 // Corresponds to the input_id struct.
 type device_info struct {
 	bustype, vendor, product, version uint16
@@ -320,11 +1470,16 @@ func keys(cap *map[int][]int) []int {
 	return slice
 }
 
-// Determine if a path exist and is a character input device.
+// Major device number of the input subsystem (INPUT_MAJOR).
+const input_major = 13
+
+// Determine if a path exist and is a character input device. Only the
+// device number is checked, so this does not require opening the device;
+// the input major number is shared with the legacy mouse and joystick
+// interfaces, which therefore also pass.
 func IsInputDevice(path string) bool {
 	fi, err := os.Stat(path)
-
-	if os.IsNotExist(err) {
+	if err != nil {
 		return false
 	}
 
@@ -333,7 +1488,17 @@ func IsInputDevice(path string) bool {
 		return false
 	}
 
-	return true
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+
+	return device_major(uint64(st.Rdev)) == input_major
+}
+
+// Extract the major number from a device number (see gnu_dev_major).
+func device_major(rdev uint64) uint64 {
+	return ((rdev >> 8) & 0xfff) | ((rdev >> 32) &^ 0xfff)
 }
 
 // Return a list of accessible input device names matched by
@@ -355,6 +1520,37 @@ func ListInputDevicePaths(device_glob string) ([]string, error) {
 	return devices, nil
 }
 
+// Like ListInputDevices, but open at most max devices and return the
+// number of input device nodes that were skipped because of the limit.
+// Nodes are visited in the lexical order of their paths, so event10 comes
+// before event2 and which devices fall beyond the limit depends on naming
+// rather than on the devices themselves; a node that cannot be opened does
+// not count towards the limit. Every returned device is fully opened.
+func ListInputDevicesLimit(device_glob string, max int) ([]*InputDevice, int, error) {
+	if max < 0 {
+		return nil, 0, fmt.Errorf("invalid device limit %d", max)
+	}
+	fns, err := ListInputDevicePaths(device_glob)
+	if err != nil {
+		return nil, 0, err
+	}
+	devices, skipped := open_devices_limit(fns, max, Open)
+	return devices, skipped, nil
+}
+
+func open_devices_limit(fns []string, max int, open func(string) (*InputDevice, error)) ([]*InputDevice, int) {
+	devices := make([]*InputDevice, 0)
+	for i, fn := range fns {
+		if len(devices) == max {
+			return devices, len(fns) - i
+		}
+		if dev, err := open(fn); err == nil {
+			devices = append(devices, dev)
+		}
+	}
+	return devices, 0
+}
+
 // Return a list of accessible input devices matched by deviceglob
 // (default '/dev/input/event/*').
 func ListInputDevices(device_glob_arg ...string) ([]*InputDevice, error) {
@@ -376,6 +1572,165 @@ func ListInputDevices(device_glob_arg ...string) ([]*InputDevice, error) {
 	return devices, nil
 }
 
+// Like ListInputDevices, but the devices are ordered by InputDeviceLess
+// rather than by the filesystem-dependent glob order.
+func ListInputDevicesSorted(device_glob_arg ...string) ([]*InputDevice, error) {
+	devices, err := ListInputDevices(device_glob_arg...)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return InputDeviceLess(devices[i], devices[j])
+	})
+	return devices, nil
+}
+
+// Report whether device a sorts before device b: by name, then by
+// physical topology, then by devnode.
+func InputDeviceLess(a, b *InputDevice) bool {
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	if a.Phys != b.Phys {
+		return a.Phys < b.Phys
+	}
+	return a.Fn < b.Fn
+}
+
+// Collapse devices that refer to the same event node (for instance through
+// different symlinks), or that share a non-empty unique identifier
+// together with their name and ids. The first device of each group is
+// kept and the others are closed.
+func Deduplicate(devices []*InputDevice) []*InputDevice {
+	seen := make(map[string]bool)
+	unique := make([]*InputDevice, 0, len(devices))
+
+	for _, dev := range devices {
+		ids := []string{"node:" + resolve_devnode(dev.Fn)}
+		if dev.Uniq != "" {
+			ids = append(ids, fmt.Sprintf("uniq:%04x:%04x:%04x:%s:%s",
+				dev.Bustype, dev.Vendor, dev.Product, dev.Name, dev.Uniq))
+		}
+
+		duplicate := false
+		for _, id := range ids {
+			duplicate = duplicate || seen[id]
+			seen[id] = true
+		}
+
+		if duplicate {
+			dev.Close()
+			continue
+		}
+		unique = append(unique, dev)
+	}
+
+	return unique
+}
+
+// Resolve symlinks in a devnode path, falling back to the path as given.
+func resolve_devnode(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// Find the first input device supporting all of the given force feedback
+// effect types (FF_RUMBLE, FF_PERIODIC, ...). The device is opened for
+// reading and writing, since playing effects requires writing to it.
+func FindFFDevice(effects ...int) (*InputDevice, error) {
+	paths, err := ListInputDevicePaths("/dev/input/event*")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		dev, err := OpenReadWrite(path)
+		if err != nil {
+			continue
+		}
+		if supports_ff(dev, effects) {
+			return dev, nil
+		}
+		dev.Close()
+	}
+
+	names := make([]string, len(effects))
+	for i, effect := range effects {
+		names[i] = CodeName(EV_FF, effect)
+	}
+	return nil, fmt.Errorf("no force feedback device supporting [%s]", strings.Join(names, " "))
+}
+
+// Report whether a device has the EV_FF capability and all the effects.
+func supports_ff(dev *InputDevice, effects []int) bool {
+	for ctype, codes := range dev.Capabilities {
+		if ctype.Type != EV_FF {
+			continue
+		}
+		supported := make(map[int]bool)
+		for _, code := range codes {
+			supported[code.Code] = true
+		}
+		for _, effect := range effects {
+			if !supported[effect] {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Open the first accessible input device matching one of names. Names are
+// tried in order; for each name an exact match is preferred over a
+// substring match. All other enumerated devices are closed.
+func OpenByNames(names ...string) (*InputDevice, error) {
+	devices, _ := ListInputDevices()
+
+	dev := select_by_names(devices, names)
+	for _, d := range devices {
+		if d != dev {
+			d.Close()
+		}
+	}
+
+	if dev == nil {
+		return nil, fmt.Errorf("no input device matching any of %q", names)
+	}
+	return dev, nil
+}
+
+func select_by_names(devices []*InputDevice, names []string) *InputDevice {
+	for _, name := range names {
+		for _, dev := range devices {
+			if dev.Name == name {
+				return dev
+			}
+		}
+		for _, dev := range devices {
+			if strings.Contains(dev.Name, name) {
+				return dev
+			}
+		}
+	}
+	return nil
+}
+
+// Return the numbers of the bits set in a kernel bitmap, up to and
+// including max.
+func bits_to_codes(bits []byte, max int) []int {
+	codes := make([]int, 0)
+	for code := 0; code <= max && code/8 < len(bits); code++ {
+		if bits[code/8]&(1<<uint(code%8)) != 0 {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
 func bytes_to_string(b *[MAX_NAME_SIZE]byte) string {
 	idx := bytes.IndexByte(b[:], 0)
 	return string(b[:idx])
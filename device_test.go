@@ -0,0 +1,2053 @@
+// +build linux
+
+package evdev
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/poller"
+)
+
+// Return a device whose File is the read end of a pipe, and the write end
+// through which the test feeds it.
+func newPipeDevice(t *testing.T) (*InputDevice, *poller.FD) {
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	r, err := poller.NewFD(p[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := poller.NewFD(p[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		r.Close()
+		w.Close()
+	})
+	return &InputDevice{Fn: "pipe", File: r}, w
+}
+
+func encodeEvents(events ...InputEvent) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, native_endian, events)
+	return b.Bytes()
+}
+
+func writeEvents(t *testing.T, w *poller.FD, events ...InputEvent) {
+	if _, err := w.Write(encodeEvents(events...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newEvent(sec int64, evtype, code uint16, value int32) InputEvent {
+	return InputEvent{Time: syscall.NsecToTimeval(sec * 1e9), Type: evtype, Code: code, Value: value}
+}
+
+func TestReadContextCancel(t *testing.T) {
+	dev, w := newPipeDevice(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := dev.readContext(ctx); err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+
+	// The device must remain usable after a cancelled read.
+	writeEvents(t, w, newEvent(1, EV_KEY, KEY_A, 1))
+	events, err := dev.readContext(context.Background())
+	if err != nil || len(events) != 1 || events[0].Code != KEY_A {
+		t.Fatalf("got %v, %v", events, err)
+	}
+}
+
+func TestReadOneContext(t *testing.T) {
+	dev, w := newPipeDevice(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := dev.ReadOneContext(ctx); err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+
+	writeEvents(t, w, newEvent(1, EV_KEY, KEY_A, 1), newEvent(1, EV_KEY, KEY_B, 1))
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	event, err := dev.ReadOneContext(ctx)
+	if err != nil || event.Code != KEY_A {
+		t.Fatalf("got %v, %v", event, err)
+	}
+	events, err := dev.ReadContext(ctx)
+	if err != nil || len(events) != 1 || events[0].Code != KEY_B {
+		t.Fatalf("got %v, %v", events, err)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	dev, w := newPipeDevice(t)
+
+	if _, err := dev.ReadTimeout(10 * time.Millisecond); err != ErrTimeout {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+
+	// The deadline is cleared again, so a later read waits for events.
+	time.AfterFunc(20*time.Millisecond, func() {
+		w.Write(encodeEvents(newEvent(1, EV_KEY, KEY_A, 1)))
+	})
+	events, err := dev.Read()
+	if err != nil || len(events) != 1 || events[0].Code != KEY_A {
+		t.Fatalf("got %v, %v", events, err)
+	}
+
+	if err := dev.SetReadDeadline(time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dev.ReadOne(); err != ErrTimeout {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+}
+
+func TestSelectByNames(t *testing.T) {
+	devices := []*InputDevice{
+		{Fn: "event0", Name: "Logitech Inc. USB Keyboard Consumer Control"},
+		{Fn: "event1", Name: "Logitech Inc. USB Keyboard"},
+		{Fn: "event2", Name: "Power Button"},
+	}
+
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"Logitech USB Keyboard", "Logitech Inc. USB Keyboard"}, "event1"},
+		{[]string{"USB Keyboard"}, "event0"},
+		{[]string{"Missing", "Power"}, "event2"},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if dev := select_by_names(devices, tt.names); dev != nil {
+			got = dev.Fn
+		}
+		if got != tt.want {
+			t.Errorf("select_by_names(%q) = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+
+	if dev := select_by_names(devices, []string{"Gamepad"}); dev != nil {
+		t.Errorf("unexpected match %s", dev.Fn)
+	}
+}
+
+func TestReadReturnsAvailableEvents(t *testing.T) {
+	dev, w := newPipeDevice(t)
+	writeEvents(t, w, newEvent(1, EV_KEY, KEY_A, 1))
+
+	done := make(chan []InputEvent)
+	go func() {
+		events, _ := dev.Read()
+		done <- events
+	}()
+
+	select {
+	case events := <-done:
+		if len(events) != 1 {
+			t.Errorf("got %d events, want 1", len(events))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read blocked with an event available")
+	}
+}
+
+func TestCapabilitiesEvtestFormat(t *testing.T) {
+	dev := &InputDevice{Capabilities: map[CapabilityType][]CapabilityCode{
+		{EV_REL, "EV_REL"}: {{REL_Y, "REL_Y"}, {REL_X, "REL_X"}},
+		{EV_SYN, "EV_SYN"}: {{SYN_REPORT, "SYN_REPORT"}},
+		{EV_KEY, "EV_KEY"}: {{BTN_RIGHT, "BTN_RIGHT"}, {BTN_LEFT, "BTN_LEFT"}},
+	}}
+
+	want := "Supported events:\n" +
+		"  Event type 0 (EV_SYN)\n" +
+		"  Event type 1 (EV_KEY)\n" +
+		"    Event code 272 (BTN_LEFT)\n" +
+		"    Event code 273 (BTN_RIGHT)\n" +
+		"  Event type 2 (EV_REL)\n" +
+		"    Event code 0 (REL_X)\n" +
+		"    Event code 1 (REL_Y)\n"
+
+	if got := dev.CapabilitiesEvtestFormat(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEmptyCapabilities(t *testing.T) {
+	for _, caps := range []map[CapabilityType][]CapabilityCode{nil, {}} {
+		dev := &InputDevice{Capabilities: caps}
+		if got := dev.CapabilitiesEvtestFormat(); got != "Supported events:\n" {
+			t.Errorf("got %q", got)
+		}
+	}
+}
+
+func TestKeyPressEvents(t *testing.T) {
+	var bits [MAX_NAME_SIZE]byte
+	bits[KEY_LEFTCTRL/8] |= 1 << (KEY_LEFTCTRL % 8)
+	bits[KEY_A/8] |= 1 << (KEY_A % 8)
+
+	codes := bits_to_codes(bits[:], KEY_MAX)
+	if len(codes) != 2 || codes[0] != KEY_LEFTCTRL || codes[1] != KEY_A {
+		t.Fatalf("bits_to_codes = %v", codes)
+	}
+
+	events := key_press_events(codes, syscall.Timeval{})
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	for i, code := range codes {
+		if ev := events[i]; ev.Type != EV_KEY || int(ev.Code) != code || ev.Value != 1 {
+			t.Errorf("event %d = %v", i, &ev)
+		}
+	}
+	if ev := events[2]; ev.Type != EV_SYN || ev.Code != SYN_REPORT {
+		t.Errorf("last event = %v, want SYN_REPORT", &ev)
+	}
+
+	if events := key_press_events(nil, syscall.Timeval{}); events != nil {
+		t.Errorf("got %v for no held keys", events)
+	}
+}
+
+func TestInputDeviceLess(t *testing.T) {
+	devices := []*InputDevice{
+		{Fn: "/dev/input/event4", Name: "Mouse", Phys: "usb-2"},
+		{Fn: "/dev/input/event3", Name: "Keyboard", Phys: "usb-1"},
+		{Fn: "/dev/input/event2", Name: "Mouse", Phys: "usb-1"},
+		{Fn: "/dev/input/event1", Name: "Mouse", Phys: "usb-1"},
+	}
+	want := []string{"/dev/input/event3", "/dev/input/event1", "/dev/input/event2", "/dev/input/event4"}
+
+	sort.Slice(devices, func(i, j int) bool { return InputDeviceLess(devices[i], devices[j]) })
+	for i := range devices {
+		if devices[i].Fn != want[i] {
+			t.Errorf("position %d: got %s, want %s", i, devices[i].Fn, want[i])
+		}
+	}
+}
+
+func TestRepeatError(t *testing.T) {
+	for _, errno := range []syscall.Errno{syscall.ENOSYS, syscall.ENOTTY, syscall.EINVAL} {
+		if err := repeat_error(errno); err != ErrUnsupported {
+			t.Errorf("repeat_error(%v) = %v, want ErrUnsupported", errno, err)
+		}
+	}
+	if err := repeat_error(syscall.EIO); err != syscall.EIO {
+		t.Errorf("repeat_error(EIO) = %v", err)
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	devices := []*InputDevice{
+		{Fn: "/dev/input/event1", Name: "Pad", Vendor: 0x45e, Product: 0x28e, Uniq: "A1"},
+		{Fn: "/dev/input/event2", Name: "Pad", Vendor: 0x45e, Product: 0x28e, Uniq: "A1"},
+		{Fn: "/dev/input/event3", Name: "Pad", Vendor: 0x45e, Product: 0x28e, Uniq: "B2"},
+		{Fn: "/dev/input/event4", Name: "Keyboard"},
+		{Fn: "/dev/input/event4", Name: "Keyboard"},
+	}
+	want := []string{"/dev/input/event1", "/dev/input/event3", "/dev/input/event4"}
+
+	unique := Deduplicate(devices)
+	if len(unique) != len(want) {
+		t.Fatalf("got %d devices, want %d", len(unique), len(want))
+	}
+	for i := range unique {
+		if unique[i].Fn != want[i] {
+			t.Errorf("position %d: got %s, want %s", i, unique[i].Fn, want[i])
+		}
+	}
+}
+
+func TestIsInputDevice(t *testing.T) {
+	for _, path := range []string{"/dev/null", "/dev/zero", "/nonexistent", "/"} {
+		if IsInputDevice(path) {
+			t.Errorf("IsInputDevice(%q) = true", path)
+		}
+	}
+
+	if got := device_major(13<<8 | 64); got != input_major {
+		t.Errorf("device_major = %d, want %d", got, input_major)
+	}
+}
+
+func TestHwdbKey(t *testing.T) {
+	dev := &InputDevice{
+		Bustype: BUS_USB, Vendor: 0x46d, Product: 0xc069, Version: 0x110,
+		Capabilities: map[CapabilityType][]CapabilityCode{
+			{EV_SYN, "EV_SYN"}: {{SYN_REPORT, "SYN_REPORT"}},
+			{EV_KEY, "EV_KEY"}: {{BTN_MIDDLE, "BTN_MIDDLE"}, {BTN_LEFT, "BTN_LEFT"}, {BTN_RIGHT, "BTN_RIGHT"}, {KEY_ESC, "KEY_ESC"}},
+			{EV_REL, "EV_REL"}: {{REL_X, "REL_X"}, {REL_Y, "REL_Y"}, {REL_WHEEL, "REL_WHEEL"}},
+			{EV_MSC, "EV_MSC"}: {{MSC_SCAN, "MSC_SCAN"}},
+		},
+	}
+
+	want := "evdev:input:b0003v046DpC069e0110-e0,1,2,4,k110,111,112,r0,1,8,am4,lsfw"
+	if got := dev.HwdbKey(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestNewAbsInfo(t *testing.T) {
+	info := NewAbsInfo(-32768, 32767, 16, 128, 4)
+	if info.Value() != 0 || info.Minimum() != -32768 || info.Maximum() != 32767 ||
+		info.Fuzz() != 16 || info.Flat() != 128 || info.Resolution() != 4 {
+		t.Errorf("unexpected AbsInfo %+v", info)
+	}
+}
+
+func TestResyncAfterDrop(t *testing.T) {
+	stream := bytes.NewReader(encodeEvents(
+		newEvent(1, EV_SYN, SYN_DROPPED, 0),
+		newEvent(1, EV_KEY, KEY_A, 1),
+		newEvent(1, EV_ABS, ABS_X, 10),
+		newEvent(1, EV_SYN, SYN_REPORT, 0),
+		newEvent(2, EV_KEY, KEY_B, 1),
+	))
+	caps := map[CapabilityType][]CapabilityCode{
+		{EV_SYN, "EV_SYN"}: nil,
+		{EV_KEY, "EV_KEY"}: nil,
+		{EV_ABS, "EV_ABS"}: nil,
+		{EV_MSC, "EV_MSC"}: nil,
+	}
+
+	if ev, _ := read_one_event(stream); ev == nil || ev.Code != SYN_DROPPED {
+		t.Fatalf("expected SYN_DROPPED, got %v", ev)
+	}
+	stale, err := resync_after_drop(stream, caps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stale, []int{EV_KEY, EV_ABS}) {
+		t.Errorf("stale types %v, want [EV_KEY EV_ABS]", stale)
+	}
+
+	// Events after the SYN_REPORT must be left unread.
+	ev, err := read_one_event(stream)
+	if err != nil || ev.Code != KEY_B {
+		t.Errorf("next event %v, %v; want KEY_B", ev, err)
+	}
+}
+
+func TestUdevRuleStub(t *testing.T) {
+	dev := &InputDevice{Name: `Logitech "USB" Laser Mouse`, Bustype: BUS_USB, Vendor: 0x46d, Product: 0xc069}
+
+	want := `SUBSYSTEM=="input", ATTRS{idVendor}=="046d", ATTRS{idProduct}=="c069", MODE="0660", GROUP="input"`
+	if got := dev.UdevRuleStub(false); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	want = `SUBSYSTEM=="input", ATTRS{idVendor}=="046d", ATTRS{idProduct}=="c069", ATTRS{name}=="Logitech ?USB? Laser Mouse", MODE="0660", GROUP="input"`
+	if got := dev.UdevRuleStub(true); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	dev.Bustype = BUS_I8042
+	want = `SUBSYSTEM=="input", ATTRS{id/vendor}=="046d", ATTRS{id/product}=="c069", MODE="0660", GROUP="input"`
+	if got := dev.UdevRuleStub(false); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestReadN(t *testing.T) {
+	dev, w := newPipeDevice(t)
+	for i := 1; i <= 5; i++ {
+		writeEvents(t, w, newEvent(int64(i), EV_KEY, KEY_A, 1))
+	}
+
+	events, err := dev.ReadN(2)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("ReadN(2) = %d events, %v", len(events), err)
+	}
+	events, err = dev.ReadN(10)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("ReadN(10) = %d events, %v", len(events), err)
+	}
+	if _, err := dev.ReadN(0); err == nil {
+		t.Error("ReadN(0) succeeded")
+	}
+}
+
+func TestSupportsFF(t *testing.T) {
+	rumble := &InputDevice{Capabilities: map[CapabilityType][]CapabilityCode{
+		{EV_FF, "EV_FF"}: {{FF_RUMBLE, "FF_RUMBLE"}, {FF_GAIN, "FF_GAIN"}},
+	}}
+	periodic := &InputDevice{Capabilities: map[CapabilityType][]CapabilityCode{
+		{EV_FF, "EV_FF"}: {{FF_PERIODIC, "FF_PERIODIC"}, {FF_SINE, "FF_SINE"}, {FF_RUMBLE, "FF_RUMBLE"}},
+	}}
+	keyboard := &InputDevice{Capabilities: map[CapabilityType][]CapabilityCode{
+		{EV_KEY, "EV_KEY"}: {{KEY_A, "KEY_A"}},
+	}}
+
+	tests := []struct {
+		dev     *InputDevice
+		effects []int
+		want    bool
+	}{
+		{rumble, []int{FF_RUMBLE}, true},
+		{rumble, []int{FF_RUMBLE, FF_PERIODIC}, false},
+		{periodic, []int{FF_RUMBLE, FF_PERIODIC}, true},
+		{periodic, nil, true},
+		{keyboard, nil, false},
+		{keyboard, []int{FF_RUMBLE}, false},
+	}
+
+	for i, tt := range tests {
+		if got := supports_ff(tt.dev, tt.effects); got != tt.want {
+			t.Errorf("case %d: supports_ff = %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestCanGrabNonEvdev(t *testing.T) {
+	dev, _ := newPipeDevice(t)
+	if ok, err := dev.CanGrab(); ok || err == nil {
+		t.Errorf("CanGrab on a pipe = %v, %v", ok, err)
+	}
+}
+
+func TestParseSysfsBitmap(t *testing.T) {
+	codes, err := ParseSysfsBitmap("120013\n")
+	if err != nil || !reflect.DeepEqual(codes, []int{EV_SYN, EV_KEY, EV_MSC, EV_LED, EV_REP}) {
+		t.Errorf("got %v, %v", codes, err)
+	}
+
+	// The last word holds the lowest bits.
+	codes, err = ParseSysfsBitmap("1 0")
+	if err != nil || !reflect.DeepEqual(codes, []int{bits.UintSize}) {
+		t.Errorf("got %v, %v", codes, err)
+	}
+
+	if _, err := ParseSysfsBitmap("xyz"); err == nil {
+		t.Error("invalid bitmap parsed without error")
+	}
+}
+
+// Create files below dir from a map of relative paths to contents.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadSysfsCapabilities(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"capabilities/ev":  "17\n",            // EV_SYN, EV_KEY, EV_REL, EV_MSC
+		"capabilities/key": "70000 0 0 0 0\n", // BTN_LEFT, BTN_RIGHT, BTN_MIDDLE on 64-bit
+		"capabilities/rel": "103\n",           // REL_X, REL_Y, REL_WHEEL
+		"capabilities/msc": "10\n",            // MSC_SCAN
+	})
+	if bits.UintSize != 64 {
+		writeTree(t, dir, map[string]string{"capabilities/key": "70000 0 0 0 0 0 0 0 0\n"})
+	}
+
+	caps, err := ReadSysfsCapabilities(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[CapabilityType][]CapabilityCode{
+		{EV_SYN, "EV_SYN"}: {},
+		{EV_KEY, "EV_KEY"}: {
+			{BTN_LEFT, "BTN_LEFT"},
+			{BTN_RIGHT, "BTN_RIGHT"},
+			{BTN_MIDDLE, "BTN_MIDDLE"},
+		},
+		{EV_REL, "EV_REL"}: {{REL_X, "REL_X"}, {REL_Y, "REL_Y"}, {REL_WHEEL, "REL_WHEEL"}},
+		{EV_MSC, "EV_MSC"}: {{MSC_SCAN, "MSC_SCAN"}},
+	}
+	if !reflect.DeepEqual(caps, want) {
+		t.Errorf("got  %v\nwant %v", caps, want)
+	}
+}
+
+// Fake EVIOCGBIT for a keyboard with a few extra event types. Like the
+// kernel, evtype 0 (EV_SYN) always reports the event type bitmap.
+func keyboardBits(evtype int, bits []byte) error {
+	set := func(codes ...int) {
+		for _, code := range codes {
+			if code/8 < len(bits) {
+				bits[code/8] |= 1 << uint(code%8)
+			}
+		}
+	}
+	switch evtype {
+	case 0:
+		set(EV_SYN, EV_KEY, EV_MSC, EV_LED, EV_REP)
+	case EV_KEY:
+		for code := KEY_ESC; code <= KEY_MICMUTE; code++ {
+			set(code)
+		}
+	case EV_MSC:
+		set(MSC_SCAN)
+	case EV_LED:
+		set(LED_NUML, LED_CAPSL, LED_SCROLLL)
+	}
+	return nil
+}
+
+func TestScanCapabilities(t *testing.T) {
+	sizes := make(map[int]int)
+	caps, _, err := scan_capabilities(func(evtype int, bits []byte) error {
+		if _, ok := sizes[evtype]; !ok {
+			sizes[evtype] = len(bits)
+		}
+		return keyboardBits(evtype, bits)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(caps) != 5 || len(caps[CapabilityType{EV_LED, "EV_LED"}]) != 3 {
+		t.Errorf("unexpected capabilities %v", caps)
+	}
+	// Buffers are sized per type instead of for KEY_MAX.
+	if sizes[0] != 4 || sizes[EV_KEY] != 96 || sizes[EV_LED] != 2 || sizes[EV_REP] != 1 {
+		t.Errorf("unexpected bitmap sizes %v", sizes)
+	}
+}
+
+func BenchmarkScanCapabilities(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		scan_capabilities(keyboardBits)
+	}
+}
+
+func TestWaitForRelease(t *testing.T) {
+	dev, w := newPipeDevice(t)
+
+	// KEY_B is already up; KEY_C is not part of the chord.
+	held := held_keys([]int{KEY_LEFTCTRL, KEY_A, KEY_C}, []int{KEY_LEFTCTRL, KEY_A, KEY_B})
+	if len(held) != 3 || !held[KEY_LEFTCTRL] || !held[KEY_A] || held[KEY_B] {
+		t.Fatalf("unexpected initial state %v", held)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- dev.wait_for_release(context.Background(), held) }()
+
+	writeEvents(t, w,
+		newEvent(1, EV_KEY, KEY_A, 0),
+		newEvent(1, EV_KEY, KEY_B, 1), // pressed while waiting
+		newEvent(1, EV_SYN, SYN_REPORT, 0),
+		newEvent(2, EV_KEY, KEY_LEFTCTRL, 0),
+		newEvent(2, EV_KEY, KEY_C, 0),
+		newEvent(2, EV_SYN, SYN_REPORT, 0))
+
+	select {
+	case err := <-done:
+		t.Fatalf("returned early with %v while KEY_B is held", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	writeEvents(t, w,
+		newEvent(3, EV_KEY, KEY_B, 0),
+		newEvent(3, EV_SYN, SYN_REPORT, 0))
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("still waiting after all keys were released")
+	}
+
+	// Nothing held: return at once without reading.
+	if err := dev.wait_for_release(context.Background(), held_keys(nil, []int{KEY_A})); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSysfsInfoFallback(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"class/input/event7/device/name": "AT Translated Set 2 keyboard\n",
+		"class/input/event7/device/uniq": "\n",
+	})
+	defer func(old string) { sysfs_root = old }(sysfs_root)
+	sysfs_root = root
+
+	// As left behind by failing EVIOCGNAME and EVIOCGUNIQ ioctls.
+	dev := &InputDevice{Fn: "/dev/input/event7"}
+	dev.set_sysfs_info()
+	if dev.Name != "AT Translated Set 2 keyboard" || dev.Uniq != "" {
+		t.Errorf("got name %q, uniq %q", dev.Name, dev.Uniq)
+	}
+
+	// Names obtained through the ioctls are kept.
+	dev = &InputDevice{Fn: "/dev/input/event7", Name: "kbd", Uniq: "0001"}
+	dev.set_sysfs_info()
+	if dev.Name != "kbd" || dev.Uniq != "0001" {
+		t.Errorf("got name %q, uniq %q", dev.Name, dev.Uniq)
+	}
+}
+
+func TestEncodeEvents(t *testing.T) {
+	dev, w := newPipeDevice(t)
+	writeEvents(t, w,
+		newEvent(1, EV_KEY, KEY_A, 1),
+		newEvent(1, EV_SYN, SYN_REPORT, 0))
+
+	var out bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	if err := dev.EncodeEvents(ctx, &out); err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+
+	want := `{"sec":1,"usec":0,"type":1,"type_name":"EV_KEY","code":30,"code_name":"KEY_A","value":1}` + "\n" +
+		`{"sec":1,"usec":0,"type":0,"type_name":"EV_SYN","code":0,"code_name":"SYN_REPORT","value":0}` + "\n"
+	if out.String() != want {
+		t.Errorf("got  %s\nwant %s", out.String(), want)
+	}
+}
+
+func TestCompositeMembers(t *testing.T) {
+	root := t.TempDir()
+	usb := "devices/pci0000:00/0000:00:14.0/usb1/1-2"
+	nodes := map[string]string{
+		"event3": usb + "/1-2:1.0/0003:046D:C52B.0001/input/input5",
+		"event4": usb + "/1-2:1.1/0003:046D:C52B.0002/input/input6",
+		"event5": "devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.0/0003:1234:0001.0003/input/input7",
+		"event6": "devices/platform/i8042/serio0/input/input2",
+	}
+	for node, dir := range nodes {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		link := filepath.Join(root, "class/input", node, "device")
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(root, dir), link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(old string) { sysfs_root = old }(sysfs_root)
+	sysfs_root = root
+
+	devices := make([]*InputDevice, 0)
+	for _, node := range []string{"event3", "event4", "event5", "event6"} {
+		devices = append(devices, &InputDevice{Fn: "/dev/input/" + node})
+	}
+
+	members := composite_members(devices, devices[1])
+	if len(members) != 2 || members[0] != devices[1] || members[1] != devices[0] {
+		t.Errorf("got %v", members)
+	}
+	if members := composite_members(devices, devices[3]); len(members) != 1 {
+		t.Errorf("non-USB device got %d members", len(members))
+	}
+}
+
+func TestCompositeRead(t *testing.T) {
+	kbd, kw := newPipeDevice(t)
+	media, mw := newPipeDevice(t)
+	c := new_composite([]*InputDevice{kbd, media})
+
+	writeEvents(t, mw, newEvent(1, EV_KEY, KEY_VOLUMEUP, 1))
+	dev, events, err := c.Read()
+	if err != nil || dev != media || len(events) != 1 || events[0].Code != KEY_VOLUMEUP {
+		t.Fatalf("got %v %v %v", dev, events, err)
+	}
+
+	writeEvents(t, kw, newEvent(2, EV_KEY, KEY_A, 1))
+	dev, events, err = c.Read()
+	if err != nil || dev != kbd || len(events) != 1 || events[0].Code != KEY_A {
+		t.Fatalf("got %v %v %v", dev, events, err)
+	}
+
+	c.Close()
+	if _, _, err := c.Read(); err != io.EOF {
+		t.Errorf("got %v after Close, want io.EOF", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestOpenDevicesLimit(t *testing.T) {
+	opened := make([]string, 0)
+	open := func(fn string) (*InputDevice, error) {
+		if fn == "event1" {
+			return nil, syscall.EACCES
+		}
+		opened = append(opened, fn)
+		return &InputDevice{Fn: fn}, nil
+	}
+
+	fns := []string{"event0", "event1", "event10", "event2", "event3", "event4"}
+	devices, skipped := open_devices_limit(fns, 3, open)
+	if len(devices) != 3 || skipped != 2 {
+		t.Fatalf("got %d devices, %d skipped", len(devices), skipped)
+	}
+	if !reflect.DeepEqual(opened, []string{"event0", "event10", "event2"}) {
+		t.Errorf("opened %v", opened)
+	}
+
+	if devices, skipped := open_devices_limit(fns[:2], 3, open); len(devices) != 1 || skipped != 0 {
+		t.Errorf("got %d devices, %d skipped", len(devices), skipped)
+	}
+}
+
+func TestNodeInUse(t *testing.T) {
+	proc := t.TempDir()
+	node := "/dev/input/event3"
+	links := map[string]string{
+		"100/fd/0": "/dev/null",
+		"100/fd/1": "/dev/input/event4",
+		"200/fd/5": node, // ourselves
+	}
+	for link, target := range links {
+		path := filepath.Join(proc, link)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTree(t, proc, map[string]string{"self/fd/0": "", "uptime": ""})
+
+	if used, err := node_in_use(proc, node, 200); err != nil || used {
+		t.Errorf("got %v, %v; want not in use", used, err)
+	}
+
+	if err := os.Symlink(node, filepath.Join(proc, "100/fd/7")); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := node_in_use(proc, node, 200); err != nil || !used {
+		t.Errorf("got %v, %v; want in use", used, err)
+	}
+}
+
+func TestEventMaskBits(t *testing.T) {
+	bits, err := event_mask_bits(EV_KEY, []int{KEY_A, KEY_B})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bits) != (KEY_MAX+1)/8 || bits[3] != 0x40 || bits[6] != 0x01 {
+		t.Errorf("unexpected bitmap % x", bits[:8])
+	}
+	if codes := bits_to_codes(bits, KEY_MAX); !reflect.DeepEqual(codes, []int{KEY_A, KEY_B}) {
+		t.Errorf("got codes %v", codes)
+	}
+
+	if bits, _ := event_mask_bits(EV_SYN, []int{EV_KEY}); len(bits) != 4 || bits[0] != 0x02 {
+		t.Errorf("unexpected event type bitmap % x", bits)
+	}
+	if _, err := event_mask_bits(EV_LED, []int{LED_MAX + 1}); err == nil {
+		t.Error("out of range code accepted")
+	}
+}
+
+func TestCheckMaskCodes(t *testing.T) {
+	caps := map[CapabilityType][]CapabilityCode{
+		{EV_SYN, "EV_SYN"}: {},
+		{EV_KEY, "EV_KEY"}: {{KEY_A, "KEY_A"}, {KEY_B, "KEY_B"}},
+	}
+	if err := check_mask_codes(caps, EV_KEY, []int{KEY_A, KEY_B}); err != nil {
+		t.Error(err)
+	}
+	if err := check_mask_codes(caps, EV_KEY, []int{KEY_C}); err == nil {
+		t.Error("unsupported key accepted")
+	}
+	if err := check_mask_codes(caps, EV_REL, nil); err == nil {
+		t.Error("unsupported event type accepted")
+	}
+	if err := check_mask_codes(caps, EV_SYN, []int{EV_KEY}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFlattenCapabilities(t *testing.T) {
+	caps, _, err := scan_capabilities(keyboardBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flat := flatten_capabilities(caps)
+
+	if len(flat) != len(caps) {
+		t.Fatalf("got %d types, want %d", len(flat), len(caps))
+	}
+	for ctype, ccodes := range caps {
+		codes := flat[ctype.Type]
+		if len(codes) != len(ccodes) {
+			t.Errorf("%s: got %d codes, want %d", ctype.Name, len(codes), len(ccodes))
+			continue
+		}
+		for i, c := range sorted_capability_codes(ccodes) {
+			if codes[i] != c.Code {
+				t.Errorf("%s: got code %d at %d, want %d", ctype.Name, codes[i], i, c.Code)
+			}
+		}
+	}
+}
+
+func TestReadSysfsLEDs(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"name":                               "AT Translated Set 2 keyboard\n",
+		"input5::capslock/brightness":        "1\n",
+		"input5::numlock/brightness":         "0\n",
+		"leds/input5::scrolllock/brightness": "0\n",
+		"capabilities/led":                   "7\n",
+		"power/control":                      "auto\n",
+	})
+
+	leds, err := ReadSysfsLEDs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, led := range leds {
+		names = append(names, led.Function())
+	}
+	if !reflect.DeepEqual(names, []string{"capslock", "numlock", "scrolllock"}) {
+		t.Fatalf("got %v", names)
+	}
+
+	if v, err := leds[0].Brightness(); err != nil || v != 1 {
+		t.Errorf("got brightness %d, %v", v, err)
+	}
+	if err := leds[1].SetBrightness(1); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := leds[1].Brightness(); err != nil || v != 1 {
+		t.Errorf("got brightness %d, %v after SetBrightness", v, err)
+	}
+}
+
+func TestReadRevoked(t *testing.T) {
+	dev, w := newPipeDevice(t)
+	writeEvents(t, w, newEvent(1, EV_KEY, KEY_A, 1))
+
+	// A pipe cannot be revoked.
+	if err := dev.Revoke(); err == nil {
+		t.Fatal("revoked a pipe")
+	}
+	if _, err := dev.ReadOne(); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&dev.revoked, 1)
+	if _, err := dev.Read(); err != ErrRevoked {
+		t.Errorf("Read: got %v, want ErrRevoked", err)
+	}
+	if _, err := dev.ReadOne(); err != ErrRevoked {
+		t.Errorf("ReadOne: got %v, want ErrRevoked", err)
+	}
+	if _, err := dev.ReadN(4); err != ErrRevoked {
+		t.Errorf("ReadN: got %v, want ErrRevoked", err)
+	}
+	if err := dev.revoked_error(ErrDeviceHangup); err != ErrRevoked {
+		t.Errorf("got %v for a hangup after Revoke", err)
+	}
+}
+
+func TestNotOpen(t *testing.T) {
+	dev := &InputDevice{}
+
+	if _, err := dev.Read(); err != ErrNotOpen {
+		t.Errorf("Read: got %v", err)
+	}
+	if _, err := dev.ReadN(4); err != ErrNotOpen {
+		t.Errorf("ReadN: got %v", err)
+	}
+	if _, err := dev.ReadOne(); err != ErrNotOpen {
+		t.Errorf("ReadOne: got %v", err)
+	}
+	if _, err := dev.ResyncAfterDrop(); err != ErrNotOpen {
+		t.Errorf("ResyncAfterDrop: got %v", err)
+	}
+	if err := dev.EncodeEvents(context.Background(), ioutil.Discard); err != ErrNotOpen {
+		t.Errorf("EncodeEvents: got %v", err)
+	}
+	if err := dev.Grab(); err != ErrNotOpen {
+		t.Errorf("Grab: got %v", err)
+	}
+	if err := dev.Release(); err != ErrNotOpen {
+		t.Errorf("Release: got %v", err)
+	}
+	if _, err := dev.CanGrab(); err != ErrNotOpen {
+		t.Errorf("CanGrab: got %v", err)
+	}
+	if _, _, err := dev.GetRepeatRate(); err != ErrNotOpen {
+		t.Errorf("GetRepeatRate: got %v", err)
+	}
+	if _, err := dev.KeyState(); err != ErrNotOpen {
+		t.Errorf("KeyState: got %v", err)
+	}
+	if _, err := dev.AbsInfo(ABS_X); err != ErrNotOpen {
+		t.Errorf("AbsInfo: got %v", err)
+	}
+	if err := dev.SetEventMaskCodes(EV_KEY, KEY_A); err != ErrNotOpen {
+		t.Errorf("SetEventMaskCodes: got %v", err)
+	}
+	if err := dev.Revoke(); err != ErrNotOpen {
+		t.Errorf("Revoke: got %v", err)
+	}
+	if err := dev.Close(); err != ErrNotOpen {
+		t.Errorf("Close: got %v", err)
+	}
+	if s := dev.String(); !strings.Contains(s, "(fd -1)") {
+		t.Errorf("String: got %q", s)
+	}
+}
+
+// Serves the same bytes on every read.
+type repeatReader []byte
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	return copy(p, r), nil
+}
+
+func TestEventBufferRead(t *testing.T) {
+	want := []InputEvent{
+		newEvent(1, EV_KEY, KEY_A, 1),
+		newEvent(1, EV_MSC, MSC_SCAN, -458756),
+		newEvent(1, EV_SYN, SYN_REPORT, 0),
+	}
+	want[0].Time.Usec = 123456
+
+	var b event_buffer
+	for i := 0; i < 2; i++ {
+		events, err := b.read(repeatReader(encodeEvents(want...)), 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("got %v\nwant %v", events, want)
+		}
+	}
+
+	dev, w := newPipeDevice(t)
+	writeEvents(t, w, want...)
+	first, err := dev.ReadShared()
+	if err != nil || !reflect.DeepEqual(first, want) {
+		t.Fatalf("got %v, %v", first, err)
+	}
+	writeEvents(t, w, newEvent(2, EV_KEY, KEY_A, 0))
+	if _, err := dev.ReadShared(); err != nil {
+		t.Fatal(err)
+	}
+	if first[0].Value != 0 {
+		t.Error("ReadShared did not reuse its buffer")
+	}
+}
+
+func TestReadInto(t *testing.T) {
+	dev, w := newPipeDevice(t)
+	writeEvents(t, w,
+		newEvent(1, EV_REL, REL_X, 3),
+		newEvent(1, EV_REL, REL_Y, -2),
+		newEvent(1, EV_SYN, SYN_REPORT, 0))
+
+	events := make([]InputEvent, 2)
+	n, err := dev.ReadInto(events)
+	if err != nil || n != 2 || events[1].Code != REL_Y {
+		t.Fatalf("got %d, %v: %v", n, err, events)
+	}
+	n, err = dev.ReadInto(events)
+	if err != nil || n != 1 || events[0].Code != SYN_REPORT {
+		t.Fatalf("got %d, %v: %v", n, err, events)
+	}
+
+	var buf event_buffer
+	var r io.Reader = repeatReader(encodeEvents(newEvent(1, EV_REL, REL_X, 3)))
+	allocs := testing.AllocsPerRun(100, func() {
+		buf.read_into(r, events)
+	})
+	if allocs != 0 {
+		t.Errorf("read_into allocated %v times per call", allocs)
+	}
+}
+
+func benchmarkRead(b *testing.B, read func(io.Reader) ([]InputEvent, error)) {
+	var r io.Reader = repeatReader(encodeEvents(
+		newEvent(1, EV_REL, REL_X, 3),
+		newEvent(1, EV_REL, REL_Y, -2),
+		newEvent(1, EV_SYN, SYN_REPORT, 0)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := read(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	benchmarkRead(b, func(r io.Reader) ([]InputEvent, error) {
+		return read_events(r, 16)
+	})
+}
+
+func BenchmarkReadShared(b *testing.B) {
+	var buf event_buffer
+	benchmarkRead(b, func(r io.Reader) ([]InputEvent, error) {
+		return buf.read(r, 16)
+	})
+}
+
+func TestReleaseEvents(t *testing.T) {
+	var r io.Reader = repeatReader(encodeEvents(newEvent(1, EV_REL, REL_X, 3)))
+	allocs := testing.AllocsPerRun(100, func() {
+		events, _ := read_events(r, 16)
+		ReleaseEvents(events)
+	})
+	// Only the slice header that goes into the pool is allocated.
+	if allocs > 1 {
+		t.Errorf("read_events allocated %v times per call", allocs)
+	}
+
+	// Stale events from an earlier, longer read must not reappear.
+	long := make([]InputEvent, 0)
+	for i := 0; i < 16; i++ {
+		long = append(long, newEvent(1, EV_REL, REL_X, int32(i)))
+	}
+	events, _ := read_events(repeatReader(encodeEvents(long...)), 16)
+	ReleaseEvents(events)
+	events, err := read_events(r, 16)
+	if err != nil || len(events) != 1 {
+		t.Errorf("got %v, %v", events, err)
+	}
+}
+
+func BenchmarkReadReleased(b *testing.B) {
+	benchmarkRead(b, func(r io.Reader) ([]InputEvent, error) {
+		events, err := read_events(r, 16)
+		ReleaseEvents(events)
+		return nil, err
+	})
+}
+
+func BenchmarkReadOne(b *testing.B) {
+	benchmarkRead(b, func(r io.Reader) ([]InputEvent, error) {
+		_, err := read_one_event(r)
+		return nil, err
+	})
+}
+
+func BenchmarkDecodeEvent(b *testing.B) {
+	raw := encodeEvents(newEvent(1, EV_REL, REL_X, 3))
+	var ev InputEvent
+	for i := 0; i < b.N; i++ {
+		decode_event(raw, &ev)
+	}
+}
+
+// Reference for BenchmarkDecodeEvent: decoding with reflection, as the
+// read path used to.
+func BenchmarkDecodeEventBinaryRead(b *testing.B) {
+	raw := encodeEvents(newEvent(1, EV_REL, REL_X, 3))
+	var ev InputEvent
+	for i := 0; i < b.N; i++ {
+		binary.Read(bytes.NewReader(raw), native_endian, &ev)
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := monotonic_now()
+	gaps := []time.Duration{0, 8 * time.Millisecond, 16 * time.Millisecond, 250 * time.Millisecond}
+
+	var last time.Duration
+	for i, gap := range gaps {
+		ev := InputEvent{Time: syscall.NsecToTimeval(int64(now + gap)), Type: EV_KEY, Code: KEY_A}
+		rel := ev.RelativeTime()
+		if i == 0 {
+			if rel < 0 || rel > now {
+				t.Fatalf("relative time %s of a current event out of range", rel)
+			}
+		} else if rel-last != gap-gaps[i-1] {
+			t.Errorf("gap %s, want %s", rel-last, gap-gaps[i-1])
+		}
+		last = rel
+	}
+
+	dev, _ := newPipeDevice(t)
+	if err := dev.SetMonotonicClock(); err == nil {
+		t.Error("set the clock of a pipe")
+	}
+	if err := dev.SetClockID(CLOCK_BOOTTIME); err == nil {
+		t.Error("set the clock of a pipe")
+	}
+}
+
+func TestMonotonicTime(t *testing.T) {
+	ev := InputEvent{Time: syscall.NsecToTimeval(int64(monotonic_now() - 50*time.Millisecond))}
+	latency := time.Since(ev.MonotonicTime())
+	if latency < 50*time.Millisecond || latency > time.Second {
+		t.Errorf("got latency %s, want about 50ms", latency)
+	}
+}
+
+func TestEventNumber(t *testing.T) {
+	dev := &InputDevice{Fn: "/dev/input/event12"}
+	if n, err := dev.EventNumber(); err != nil || n != 12 {
+		t.Errorf("got %d, %v", n, err)
+	}
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"event3": ""})
+	link := filepath.Join(dir, "usb-Logitech-event-kbd")
+	if err := os.Symlink("event3", link); err != nil {
+		t.Fatal(err)
+	}
+	dev = &InputDevice{Fn: link}
+	if n, err := dev.EventNumber(); err != nil || n != 3 {
+		t.Errorf("symlink: got %d, %v", n, err)
+	}
+
+	for _, fn := range []string{"/dev/input/mouse0", "/dev/input/event", "/dev/input/event-1"} {
+		if n, err := (&InputDevice{Fn: fn}).EventNumber(); err == nil {
+			t.Errorf("%s: got %d", fn, n)
+		}
+	}
+}
+
+func TestDeviceNumber(t *testing.T) {
+	root := t.TempDir()
+	input := filepath.Join(root, "devices/platform/i8042/serio0/input/input5")
+	if err := os.MkdirAll(input, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "class/input/event4"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(input, filepath.Join(root, "class/input/event4/device")); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { sysfs_root = old }(sysfs_root)
+	sysfs_root = root
+
+	dev := &InputDevice{Fn: "/dev/input/event4"}
+	if n, err := dev.DeviceNumber(); err != nil || n != 5 {
+		t.Errorf("got %d, %v", n, err)
+	}
+}
+
+var _ EventSource = (*InputDevice)(nil)
+
+func TestSetKeymap(t *testing.T) {
+	dev, w := newPipeDevice(t)
+	dev.SetKeymap(map[int]int{KEY_CAPSLOCK: KEY_ESC})
+
+	writeEvents(t, w,
+		newEvent(1, EV_KEY, KEY_CAPSLOCK, 1),
+		newEvent(1, EV_KEY, KEY_A, 1))
+	events, err := dev.Read()
+	if err != nil || len(events) != 2 || events[0].Code != KEY_ESC || events[1].Code != KEY_A {
+		t.Fatalf("got %v, %v", events, err)
+	}
+
+	writeEvents(t, w, newEvent(2, EV_KEY, KEY_CAPSLOCK, 0))
+	if ev, err := dev.ReadOne(); err != nil || ev.Code != KEY_ESC {
+		t.Fatalf("got %v, %v", ev, err)
+	}
+
+	dev.SetKeymap(nil)
+	writeEvents(t, w, newEvent(3, EV_KEY, KEY_CAPSLOCK, 1))
+	if events, err := dev.ReadShared(); err != nil || events[0].Code != KEY_CAPSLOCK {
+		t.Fatalf("got %v, %v after removing the keymap", events, err)
+	}
+}
+
+func TestDrainAll(t *testing.T) {
+	dev, w := newPipeDevice(t)
+
+	pending := make([]InputEvent, 0)
+	for i := 0; i < 100; i++ {
+		pending = append(pending, newEvent(int64(i+1), EV_REL, REL_X, int32(i)))
+	}
+	writeEvents(t, w, pending...)
+
+	events, err := dev.DrainAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(events, pending) {
+		t.Errorf("got %d events, want the %d pending", len(events), len(pending))
+	}
+
+	events, err = dev.DrainAll()
+	if err != nil || events == nil || len(events) != 0 {
+		t.Errorf("got %v, %v from a drained device", events, err)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	dev, w := newPipeDevice(t)
+
+	// One read returns a frame and a half; the rest of the second frame
+	// arrives later.
+	writeEvents(t, w,
+		newEvent(1, EV_ABS, ABS_X, 10),
+		newEvent(1, EV_ABS, ABS_Y, 20),
+		newEvent(1, EV_SYN, SYN_REPORT, 0),
+		newEvent(2, EV_ABS, ABS_X, 11))
+
+	frame, err := dev.ReadFrame()
+	if err != nil || len(frame) != 3 || frame[2].Code != SYN_REPORT {
+		t.Fatalf("got %v, %v", frame, err)
+	}
+
+	writeEvents(t, w,
+		newEvent(2, EV_ABS, ABS_Y, 21),
+		newEvent(2, EV_SYN, SYN_REPORT, 0))
+	frame, err = dev.ReadFrame()
+	want := []InputEvent{
+		newEvent(2, EV_ABS, ABS_X, 11),
+		newEvent(2, EV_ABS, ABS_Y, 21),
+		newEvent(2, EV_SYN, SYN_REPORT, 0),
+	}
+	if err != nil || !reflect.DeepEqual(frame, want) {
+		t.Fatalf("got %v, %v, want %v", frame, err, want)
+	}
+}
+
+func TestSetBatchSize(t *testing.T) {
+	dev, w := newPipeDevice(t)
+
+	pending := make([]InputEvent, 0)
+	for i := 0; i < 40; i++ {
+		pending = append(pending, newEvent(1, EV_REL, REL_X, int32(i)))
+	}
+	writeEvents(t, w, pending...)
+
+	for _, size := range []int{1, 32, 0} {
+		dev.SetBatchSize(size)
+		events, err := dev.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := size
+		if size == 0 {
+			want = 7 // the rest, fewer than the default of 16
+		}
+		if len(events) != want {
+			t.Errorf("batch size %d: got %d events, want %d", size, len(events), want)
+		}
+	}
+}
+
+func TestTryRead(t *testing.T) {
+	dev, w := newPipeDevice(t)
+
+	if events, err := dev.TryRead(); err != ErrNoEvents {
+		t.Fatalf("got %v, %v, want ErrNoEvents", events, err)
+	}
+
+	writeEvents(t, w, newEvent(1, EV_KEY, KEY_A, 1), newEvent(1, EV_SYN, SYN_REPORT, 0))
+	events, err := dev.TryRead()
+	if err != nil || len(events) != 2 || events[0].Code != KEY_A {
+		t.Fatalf("got %v, %v", events, err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	dev, w := newPipeDevice(t)
+	dev.SetBatchSize(3)
+
+	writeEvents(t, w,
+		newEvent(1, EV_KEY, KEY_A, 1),
+		newEvent(1, EV_KEY, KEY_B, 1),
+		newEvent(1, EV_KEY, KEY_C, 1),
+		newEvent(1, EV_SYN, SYN_REPORT, 0),
+		newEvent(2, EV_KEY, KEY_A, 0))
+	if _, err := dev.ReadFrame(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The partial frame buffered by ReadFrame counts as well.
+	if n, err := dev.Drain(); err != nil || n != 1 {
+		t.Fatalf("got %d, %v, want 1 discarded event", n, err)
+	}
+	if n, err := dev.Drain(); err != nil || n != 0 {
+		t.Fatalf("got %d, %v from a drained device", n, err)
+	}
+}
+
+func TestSetRepeatViaEvents(t *testing.T) {
+	r, w := newPipeDevice(t)
+	dev := &InputDevice{Fn: "pipe", File: w}
+
+	if err := dev.SetRepeatViaEvents(250, 33); err != ErrUnsupported {
+		t.Fatalf("got %v without EV_REP", err)
+	}
+
+	dev.Capabilities = map[CapabilityType][]CapabilityCode{
+		{EV_KEY, "EV_KEY"}: {{KEY_A, "KEY_A"}},
+		{EV_REP, "EV_REP"}: {},
+	}
+	if err := dev.SetRepeatViaEvents(250, 33); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := r.DrainAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []InputEvent{
+		{Type: EV_REP, Code: REP_DELAY, Value: 250},
+		{Type: EV_REP, Code: REP_PERIOD, Value: 33},
+		{Type: EV_SYN, Code: SYN_REPORT},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got  %v\nwant %v", events, want)
+	}
+}
+
+func TestAbsAxes(t *testing.T) {
+	caps := map[CapabilityType][]CapabilityCode{
+		{EV_KEY, "EV_KEY"}: {{BTN_SOUTH, "BTN_SOUTH"}},
+		{EV_ABS, "EV_ABS"}: {{ABS_HAT0X, "ABS_HAT0X"}, {ABS_X, "ABS_X"}, {ABS_RZ, "ABS_RZ"}},
+	}
+	ranges := map[int]AbsInfo{
+		ABS_X:     NewAbsInfo(-32768, 32767, 16, 128, 0),
+		ABS_RZ:    NewAbsInfo(0, 255, 0, 0, 0),
+		ABS_HAT0X: NewAbsInfo(-1, 1, 0, 0, 0),
+	}
+
+	axes, err := abs_axes(caps, func(code int) (AbsInfo, error) {
+		return ranges[code], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AxisInfo{
+		{ABS_X, "ABS_X", ranges[ABS_X]},
+		{ABS_RZ, "ABS_RZ", ranges[ABS_RZ]},
+		{ABS_HAT0X, "ABS_HAT0X", ranges[ABS_HAT0X]},
+	}
+	if !reflect.DeepEqual(axes, want) {
+		t.Errorf("got  %v\nwant %v", axes, want)
+	}
+	if axes[0].Info.Minimum() != -32768 || axes[0].Info.Flat() != 128 {
+		t.Errorf("unexpected ABS_X range %v", axes[0].Info)
+	}
+
+	if _, err := abs_axes(caps, func(int) (AbsInfo, error) { return AbsInfo{}, syscall.EINVAL }); err != syscall.EINVAL {
+		t.Errorf("got %v, want EINVAL", err)
+	}
+}
+
+func TestScanCapabilitiesSkipsFailingTypes(t *testing.T) {
+	caps, skipped, err := scan_capabilities(func(evtype int, bits []byte) error {
+		if evtype == EV_MSC {
+			return syscall.EINVAL
+		}
+		return keyboardBits(evtype, bits)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(skipped, []int{EV_MSC}) {
+		t.Errorf("got skipped %v, want [EV_MSC]", skipped)
+	}
+	if _, ok := caps[CapabilityType{EV_MSC, "EV_MSC"}]; ok || len(caps) != 4 {
+		t.Errorf("unexpected capabilities %v", caps)
+	}
+	if len(caps[CapabilityType{EV_KEY, "EV_KEY"}]) == 0 {
+		t.Error("EV_KEY codes lost")
+	}
+
+	// Without the event type bitmap there is nothing to go on.
+	if _, _, err := scan_capabilities(func(int, []byte) error { return syscall.EIO }); err != syscall.EIO {
+		t.Errorf("got %v, want EIO", err)
+	}
+}
+
+func TestVerifyFd(t *testing.T) {
+	dev, w := newPipeDevice(t)
+	if err := verify_fd(dev.File.Sysfd()); err != nil {
+		t.Errorf("idle pipe: %v", err)
+	}
+
+	writeEvents(t, w, newEvent(1, EV_KEY, KEY_A, 1))
+	if err := verify_fd(dev.File.Sysfd()); err != nil {
+		t.Errorf("readable pipe: %v", err)
+	}
+	if ev, err := dev.ReadOne(); err != nil || ev.Code != KEY_A {
+		t.Errorf("verification consumed the pending event: %v, %v", ev, err)
+	}
+
+	// A pipe without writer reports a hangup, like a removed device.
+	w.Close()
+	if err := verify_fd(dev.File.Sysfd()); err != ErrDeviceFaulted {
+		t.Errorf("got %v, want ErrDeviceFaulted", err)
+	}
+}
+
+func TestReopenKeepsGrab(t *testing.T) {
+	dev, _ := newPipeDevice(t)
+	old := dev.File
+	dev.grabbed, dev.grabRefs = true, 2
+
+	caps, _, err := scan_capabilities(keyboardBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, _ := newPipeDevice(t)
+	fresh.Name, fresh.Vendor = "keyboard", 0x046d
+	fresh.Capabilities, fresh.CapabilitiesFlat = caps, flatten_capabilities(caps)
+	open := func() (*InputDevice, error) { return fresh, nil }
+
+	grabbed := make([]*InputDevice, 0)
+	grab := func(d *InputDevice) error {
+		grabbed = append(grabbed, d)
+		return nil
+	}
+	if err := dev.reopen(open, grab); err != nil {
+		t.Fatal(err)
+	}
+	if len(grabbed) != 1 || grabbed[0] != fresh {
+		t.Errorf("grabbed %v, want the reopened device", grabbed)
+	}
+	if dev.File != fresh.File || dev.Name != "keyboard" || dev.Vendor != 0x046d || len(dev.CapabilitiesFlat[EV_KEY]) == 0 {
+		t.Errorf("device not updated: %+v", dev)
+	}
+	if !dev.grabbed || dev.GrabCount() != 2 {
+		t.Errorf("grab state lost: grabbed %v, count %d", dev.grabbed, dev.GrabCount())
+	}
+	if err := old.Lock(); err == nil {
+		t.Error("old file not closed")
+	}
+
+	// A device that cannot be grabbed again keeps its file.
+	current := dev.File
+	fresh, _ = newPipeDevice(t)
+	err = dev.reopen(open, func(*InputDevice) error { return syscall.EBUSY })
+	if err == nil || dev.File != current {
+		t.Errorf("got %v, file replaced %v", err, dev.File != current)
+	}
+
+	// An ungrabbed device is not grabbed.
+	dev.grabbed, dev.grabRefs = false, 0
+	grabbed = grabbed[:0]
+	fresh, _ = newPipeDevice(t)
+	if err := dev.reopen(open, grab); err != nil || len(grabbed) != 0 {
+		t.Errorf("got %v, grabbed %v", err, grabbed)
+	}
+}
+
+func TestGrabRef(t *testing.T) {
+	dev, _ := newPipeDevice(t)
+
+	grabs, releases := 0, 0
+	grab := func(*InputDevice) error { grabs++; return nil }
+	release := func(*InputDevice) error { releases++; return nil }
+	check := func(step string, wantGrabs, wantReleases, wantCount int, wantGrabbed bool) {
+		t.Helper()
+		if grabs != wantGrabs || releases != wantReleases || dev.GrabCount() != wantCount || dev.grabbed != wantGrabbed {
+			t.Errorf("%s: %d grabs, %d releases, count %d, grabbed %v; want %d, %d, %d, %v",
+				step, grabs, releases, dev.GrabCount(), dev.grabbed,
+				wantGrabs, wantReleases, wantCount, wantGrabbed)
+		}
+	}
+
+	// Nested references grab and release the device once.
+	dev.grab_ref_with(grab)
+	dev.grab_ref_with(grab)
+	check("two GrabRef", 1, 0, 2, true)
+	dev.release_ref_with(release)
+	check("first ReleaseRef", 1, 0, 1, true)
+	dev.release_ref_with(release)
+	check("second ReleaseRef", 1, 1, 0, false)
+	dev.release_ref_with(release)
+	check("unmatched ReleaseRef", 1, 1, 0, false)
+
+	// GrabRef on a device grabbed through Grab does not grab it again.
+	dev.grab_with(grab)
+	dev.grab_ref_with(grab)
+	check("Grab then GrabRef", 2, 1, 1, true)
+	dev.release_ref_with(release)
+	check("ReleaseRef after Grab", 2, 2, 0, false)
+
+	// Release drops all references.
+	dev.grab_ref_with(grab)
+	dev.grab_ref_with(grab)
+	dev.release_with(release)
+	check("Release with references", 3, 3, 0, false)
+	dev.release_ref_with(release)
+	check("ReleaseRef after Release", 3, 3, 0, false)
+
+	// A failed grab takes no reference.
+	err := dev.grab_ref_with(func(*InputDevice) error { return syscall.EBUSY })
+	if err != syscall.EBUSY {
+		t.Errorf("got %v, want EBUSY", err)
+	}
+	check("failed GrabRef", 3, 3, 0, false)
+}
+
+func TestSupportedEventTypes(t *testing.T) {
+	types := SupportedEventTypes()
+	if len(types) < 10 || types[0] != (CapabilityType{EV_SYN, "EV_SYN"}) || types[1] != (CapabilityType{EV_KEY, "EV_KEY"}) {
+		t.Fatalf("got %v", types)
+	}
+	seen := make(map[int]bool)
+	for i, ctype := range types {
+		if i > 0 && ctype.Type <= types[i-1].Type {
+			t.Errorf("%s out of order", ctype.Name)
+		}
+		if ctype.Type >= EV_MAX {
+			t.Errorf("%s is not an event type", ctype.Name)
+		}
+		seen[ctype.Type] = true
+	}
+	for _, evtype := range []int{EV_REL, EV_ABS, EV_MSC, EV_SW, EV_LED, EV_SND, EV_REP, EV_FF} {
+		if !seen[evtype] {
+			t.Errorf("%s missing", TypeName(evtype))
+		}
+	}
+}
+
+// Replays a script of read results, one per call.
+type scriptedReads struct {
+	results []error
+	calls   int
+}
+
+func (s *scriptedReads) read(ctx context.Context) ([]InputEvent, error) {
+	err := s.results[s.calls]
+	s.calls++
+	if err != nil {
+		return nil, err
+	}
+	return []InputEvent{newEvent(int64(s.calls), EV_KEY, KEY_A, 1)}, nil
+}
+
+func TestReadWithPolicy(t *testing.T) {
+	ctx := context.Background()
+	noReopen := func() error { t.Fatal("unexpected reopen"); return nil }
+
+	// Transient errors are retried.
+	s := &scriptedReads{results: []error{syscall.EINTR, syscall.EAGAIN, nil}}
+	if events, err := read_with_policy(ctx, ReadErrorPolicy{}, s.read, noReopen); err != nil || len(events) != 1 || s.calls != 3 {
+		t.Errorf("transient: got %v, %v after %d reads", events, err, s.calls)
+	}
+
+	// Fatal errors end the stream.
+	for _, fatal := range []error{ErrRevoked, io.EOF, syscall.EIO} {
+		s = &scriptedReads{results: []error{fatal}}
+		if _, err := read_with_policy(ctx, ReadErrorPolicy{Reconnect: true}, s.read, noReopen); err != fatal {
+			t.Errorf("got %v, want %v", err, fatal)
+		}
+	}
+
+	// A hangup ends the stream without Reconnect.
+	s = &scriptedReads{results: []error{ErrDeviceHangup}}
+	if _, err := read_with_policy(ctx, ReadErrorPolicy{}, s.read, noReopen); err != ErrDeviceHangup {
+		t.Errorf("got %v, want ErrDeviceHangup", err)
+	}
+}
+
+func TestReadWithPolicyReconnect(t *testing.T) {
+	c := useFakeClock(t)
+	policy := ReadErrorPolicy{Reconnect: true, MaxBackoff: 300 * time.Millisecond}
+	start := clk.Now()
+
+	var failures int32 = 3
+	attempts := make(chan time.Duration, 10)
+	reopen := func() error {
+		attempts <- clk.Now().Sub(start)
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			return syscall.ENOENT
+		}
+		return nil
+	}
+
+	s := &scriptedReads{results: []error{ErrDeviceHangup, nil}}
+	done := make(chan error, 1)
+	go func() {
+		_, err := read_with_policy(context.Background(), policy, s.read, reopen)
+		done <- err
+	}()
+
+	// Attempts after 100ms, then backing off by 200ms, 300ms (capped)
+	// and 300ms.
+	for _, want := range []time.Duration{100, 300, 600, 900} {
+		want *= time.Millisecond
+		for {
+			c.mu.Lock()
+			waiting := len(c.waiters)
+			c.mu.Unlock()
+			if waiting > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		c.Advance(want - clk.Now().Sub(start))
+		if at := <-attempts; at != want {
+			t.Errorf("attempt at %s, want %s", at, want)
+		}
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if s.calls != 2 {
+		t.Errorf("got %d reads, want 2", s.calls)
+	}
+}
+
+func TestReconnectInitialBackoff(t *testing.T) {
+	c := useFakeClock(t)
+	start := clk.Now()
+	attempt := make(chan time.Duration, 1)
+	reopen := func() error {
+		attempt <- clk.Now().Sub(start)
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- reconnect(context.Background(), ReadErrorPolicy{MaxBackoff: 30 * time.Millisecond}, reopen) }()
+	for {
+		c.mu.Lock()
+		waiting := len(c.waiters)
+		c.mu.Unlock()
+		if waiting > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Advance(30 * time.Millisecond)
+	if at := <-attempt; at != 30*time.Millisecond {
+		t.Errorf("attempt at %s, want the 30ms MaxBackoff", at)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenSame(t *testing.T) {
+	newDevice := func() *InputDevice {
+		dev, _ := newPipeDevice(t)
+		dev.Bustype, dev.Vendor, dev.Product, dev.Uniq = BUS_USB, 0x046d, 0xc52b, "abc"
+		return dev
+	}
+	dev := newDevice()
+	var fresh *InputDevice
+	open := open_same(dev, func() (*InputDevice, error) { return fresh, nil })
+
+	fresh = newDevice()
+	if d, err := open(); err != nil || d != fresh {
+		t.Errorf("same device: got %v, %v", d, err)
+	}
+
+	for _, change := range []func(d *InputDevice){
+		func(d *InputDevice) { d.Bustype = BUS_BLUETOOTH },
+		func(d *InputDevice) { d.Vendor = 0x045e },
+		func(d *InputDevice) { d.Product = 0xc52c },
+		func(d *InputDevice) { d.Uniq = "def" },
+	} {
+		fresh = newDevice()
+		change(fresh)
+		if _, err := open(); err != err_device_changed {
+			t.Errorf("got %v, want err_device_changed for %+v", err, fresh)
+		}
+		if err := fresh.File.Lock(); err == nil {
+			t.Error("other device not closed")
+		}
+	}
+}
+
+func TestResyncingReader(t *testing.T) {
+	initial := new_device_state()
+	initial.abs[ABS_X] = 100
+	initial.slots = map[int][]int32{ABS_MT_POSITION_X: {10, 20}, ABS_MT_TRACKING_ID: {1, -1}}
+
+	// While events were dropped, KEY_A was released, KEY_B pressed, the
+	// pointer moved and a second touch began.
+	current := new_device_state()
+	current.keys[KEY_B] = true
+	current.abs[ABS_X] = 300
+	current.slots = map[int][]int32{ABS_MT_POSITION_X: {15, 40}, ABS_MT_TRACKING_ID: {1, 2}}
+
+	batches := [][]InputEvent{
+		{
+			newEvent(1, EV_KEY, KEY_A, 1),
+			newEvent(1, EV_ABS, ABS_X, 200),
+			newEvent(1, EV_SYN, SYN_REPORT, 0),
+			newEvent(2, EV_SYN, SYN_DROPPED, 0),
+			newEvent(2, EV_ABS, ABS_X, 250),
+		},
+		{newEvent(2, EV_ABS, ABS_X, 280)}, // still dropping
+		{
+			newEvent(3, EV_SYN, SYN_REPORT, 0),
+			newEvent(4, EV_KEY, KEY_C, 1),
+		},
+	}
+	read := func() ([]InputEvent, error) {
+		if len(batches) == 0 {
+			return nil, io.EOF
+		}
+		events := batches[0]
+		batches = batches[1:]
+		return events, nil
+	}
+	queries := []*device_state{initial, current}
+	query := func() (*device_state, error) {
+		s := queries[0]
+		queries = queries[1:]
+		return s, nil
+	}
+
+	r, err := new_resyncing_reader(read, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := r.Read()
+	if err != nil || len(events) != 3 {
+		t.Fatalf("got %v, %v", events, err)
+	}
+
+	events, err = r.Read()
+	want := []InputEvent{
+		newEvent(3, EV_KEY, KEY_A, 0),
+		newEvent(3, EV_KEY, KEY_B, 1),
+		newEvent(3, EV_ABS, ABS_X, 300),
+		newEvent(3, EV_ABS, ABS_MT_POSITION_X, 15),
+		newEvent(3, EV_ABS, ABS_MT_SLOT, 1),
+		newEvent(3, EV_ABS, ABS_MT_POSITION_X, 40),
+		newEvent(3, EV_ABS, ABS_MT_TRACKING_ID, 2),
+		newEvent(3, EV_ABS, ABS_MT_SLOT, 0),
+		newEvent(3, EV_SYN, SYN_REPORT, 0),
+		newEvent(4, EV_KEY, KEY_C, 1),
+	}
+	if err != nil || !reflect.DeepEqual(events, want) {
+		t.Fatalf("got %v, %v\nwant %v", events, err, want)
+	}
+}
+
+func TestMultiplexer(t *testing.T) {
+	dev1, _ := newPipeDevice(t)
+	dev2, w2 := newPipeDevice(t)
+
+	m, err := NewMultiplexer(dev1, dev2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeEvents(t, w2, newEvent(1, EV_KEY, KEY_A, 1), newEvent(1, EV_SYN, SYN_REPORT, 0))
+	dev, events, err := m.Wait()
+	if err != nil || dev != dev2 || len(events) != 2 || events[0].Code != KEY_A {
+		t.Fatalf("got %v, %v, %v", dev, events, err)
+	}
+
+	if err := m.Remove(dev2); err != nil {
+		t.Fatal(err)
+	}
+	writeEvents(t, w2, newEvent(2, EV_KEY, KEY_A, 0))
+
+	// Only the removed device has events, so Wait blocks until Close.
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := m.Wait()
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	m.Close()
+	select {
+	case err := <-done:
+		if err != ErrMultiplexerClosed {
+			t.Errorf("got %v, want ErrMultiplexerClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not interrupt Wait")
+	}
+}
+
+func TestBroadcaster(t *testing.T) {
+	dev, w := newPipeDevice(t)
+	b := NewBroadcaster(dev)
+
+	hotkeys := b.Subscribe(1)
+	logger := b.Subscribe(4)
+	writeEvents(t, w, newEvent(1, EV_KEY, KEY_A, 1), newEvent(1, EV_SYN, SYN_REPORT, 0))
+
+	for _, s := range []*Subscription{hotkeys, logger} {
+		select {
+		case events := <-s.Events:
+			if len(events) != 2 || events[0].Code != KEY_A {
+				t.Errorf("got %v", events)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no events delivered")
+		}
+	}
+
+	// Events keep flowing to the remaining subscriber.
+	hotkeys.Unsubscribe()
+	writeEvents(t, w, newEvent(2, EV_KEY, KEY_A, 0))
+	if events := <-logger.Events; len(events) != 1 || events[0].Value != 0 {
+		t.Errorf("got %v", events)
+	}
+
+	b.Close()
+	if _, ok := <-logger.Events; ok {
+		t.Error("subscription channel not closed by Close")
+	}
+	if err := b.Err(); err != nil {
+		t.Errorf("got %v after Close", err)
+	}
+	if _, ok := <-b.Subscribe(1).Events; ok {
+		t.Error("subscribed to a closed broadcaster")
+	}
+}
+
+func TestBroadcasterFilter(t *testing.T) {
+	dev, w := newPipeDevice(t)
+	b := NewBroadcaster(dev)
+	defer b.Close()
+
+	hotkeys := b.SubscribeFiltered(1, OverflowBlock, KeyDownOnly())
+	writeEvents(t, w, newEvent(1, EV_KEY, KEY_A, 1), newEvent(1, EV_SYN, SYN_REPORT, 0))
+	writeEvents(t, w, newEvent(2, EV_KEY, KEY_A, 0), newEvent(2, EV_SYN, SYN_REPORT, 0))
+	writeEvents(t, w, newEvent(3, EV_KEY, KEY_B, 1))
+
+	got := make([]InputEvent, 0)
+	for len(got) < 2 {
+		select {
+		case events := <-hotkeys.Events:
+			got = append(got, events...)
+		case <-time.After(time.Second):
+			t.Fatalf("got only %v", got)
+		}
+	}
+	if len(got) != 2 || got[0].Code != KEY_A || got[1].Code != KEY_B {
+		t.Errorf("got %v, want the presses of KEY_A and KEY_B", got)
+	}
+}
+
+func TestMergeStreams(t *testing.T) {
+	dev1, w1 := newPipeDevice(t)
+	dev2, w2 := newPipeDevice(t)
+	stream := MergeStreams(context.Background(), dev1, dev2)
+
+	writeEvents(t, w1, newEvent(1, EV_KEY, KEY_A, 1))
+	if de := <-stream; de.Device != dev1 || de.Err != nil || de.Event.Code != KEY_A {
+		t.Errorf("got %+v", de)
+	}
+	writeEvents(t, w2, newEvent(2, EV_KEY, KEY_B, 1))
+	if de := <-stream; de.Device != dev2 || de.Err != nil || de.Event.Code != KEY_B {
+		t.Errorf("got %+v", de)
+	}
+
+	dev1.Close()
+	if de := <-stream; de.Device != dev1 || de.Err == nil {
+		t.Errorf("got %+v, want the error of the closed device", de)
+	}
+	dev2.Close()
+	<-stream
+	if _, ok := <-stream; ok {
+		t.Error("stream not closed after all devices failed")
+	}
+}
+
+func TestMergeStreamsCancel(t *testing.T) {
+	dev1, w1 := newPipeDevice(t)
+	dev2, _ := newPipeDevice(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := NewMergedStream(ctx, 1, OverflowBlock, dev1, dev2)
+
+	// Fill the buffer so that a reader waits for room.
+	writeEvents(t, w1, newEvent(1, EV_KEY, KEY_A, 1))
+	writeEvents(t, w1, newEvent(2, EV_KEY, KEY_A, 0))
+	writeEvents(t, w1, newEvent(3, EV_KEY, KEY_B, 1))
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case de, ok := <-stream.Events:
+			if !ok {
+				return
+			}
+			if de.Err != nil {
+				t.Errorf("got %v, want no errors on cancel", de.Err)
+			}
+		case <-timeout:
+			t.Fatal("stream not closed after cancel")
+		}
+	}
+}
+
+func TestMergedStreamFilter(t *testing.T) {
+	dev1, w1 := newPipeDevice(t)
+	dev2, w2 := newPipeDevice(t)
+	stream := NewMergedStreamFiltered(context.Background(), 1, OverflowBlock, KeyDownOnly(), dev1, dev2)
+
+	writeEvents(t, w1, newEvent(1, EV_REL, REL_X, 5), newEvent(1, EV_SYN, SYN_REPORT, 0))
+	writeEvents(t, w2, newEvent(2, EV_KEY, KEY_A, 1), newEvent(2, EV_SYN, SYN_REPORT, 0))
+	if de := <-stream.Events; de.Device != dev2 || de.Event.Code != KEY_A {
+		t.Errorf("got %+v, want the press of KEY_A", de)
+	}
+
+	// Errors pass the filter.
+	dev1.Close()
+	if de := <-stream.Events; de.Device != dev1 || de.Err == nil {
+		t.Errorf("got %+v, want the error of the closed device", de)
+	}
+	dev2.Close()
+}
+
+func TestEventQueuePolicies(t *testing.T) {
+	dev := &InputDevice{}
+	batch := func(events ...InputEvent) queued_batch {
+		return queued_batch{dev: dev, events: events}
+	}
+	move := func(dx int32) InputEvent { return newEvent(1, EV_REL, REL_X, dx) }
+	values := func(q *event_queue) []int32 {
+		v := make([]int32, 0)
+		for _, b := range q.batches {
+			for _, ev := range b.events {
+				v = append(v, ev.Value)
+			}
+		}
+		return v
+	}
+
+	tests := []struct {
+		policy  OverflowPolicy
+		want    []int32
+		dropped uint64
+	}{
+		{OverflowDropOldest, []int32{2, 3}, 1},
+		{OverflowDropNewest, []int32{1, 2}, 1},
+		{OverflowCoalesce, []int32{1, 5}, 0},
+	}
+	for _, test := range tests {
+		q := new_event_queue(2, test.policy)
+		for dx := int32(1); dx <= 3; dx++ {
+			q.put(batch(move(dx)))
+		}
+		q.put(queued_batch{dev: dev, err: io.EOF}) // errors are never dropped
+		if got := values(q); !reflect.DeepEqual(got, test.want) || len(q.batches) != 3 {
+			t.Errorf("policy %d: got %v in %d batches, want %v and an error", test.policy, got, len(q.batches), test.want)
+		}
+		if q.dropped_events() != test.dropped {
+			t.Errorf("policy %d: dropped %d, want %d", test.policy, q.dropped_events(), test.dropped)
+		}
+	}
+
+	// A queue holding only errors has nothing to drop to make room, so
+	// the new batch is dropped.
+	for _, policy := range []OverflowPolicy{OverflowDropOldest, OverflowCoalesce} {
+		q := new_event_queue(1, policy)
+		q.put(queued_batch{dev: dev, err: io.EOF})
+		q.put(batch(move(1)))
+		if len(q.batches) != 1 || q.batches[0].err != io.EOF || q.dropped_events() != 1 {
+			t.Errorf("policy %d: got %v, dropped %d", policy, q.batches, q.dropped_events())
+		}
+	}
+
+	// OverflowCoalesce only merges batches of relative motion.
+	syn := newEvent(1, EV_SYN, SYN_REPORT, 0)
+	click := newEvent(1, EV_KEY, BTN_LEFT, 1)
+	q := new_event_queue(1, OverflowCoalesce)
+	q.put(batch(move(1), syn))
+	q.put(batch(move(2), newEvent(1, EV_REL, REL_Y, 4), syn))
+	want := []InputEvent{move(3), newEvent(1, EV_REL, REL_Y, 4), syn}
+	if len(q.batches) != 1 || !reflect.DeepEqual(q.batches[0].events, want) {
+		t.Errorf("got %v, want %v", q.batches, want)
+	}
+	q.put(batch(click, syn, move(2), syn))
+	want = []InputEvent{click, syn, move(2), syn}
+	if len(q.batches) != 1 || !reflect.DeepEqual(q.batches[0].events, want) || q.dropped_events() != 3 {
+		t.Errorf("got %v, dropped %d; want %v, dropped 3", q.batches, q.dropped_events(), want)
+	}
+
+	// OverflowBlock waits for room.
+	q = new_event_queue(1, OverflowBlock)
+	q.put(batch(move(1)))
+	put := make(chan bool)
+	go func() {
+		q.put(batch(move(2)))
+		put <- true
+	}()
+	select {
+	case <-put:
+		t.Fatal("put did not block on a full queue")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if b, ok := q.get(); !ok || b.events[0].Value != 1 {
+		t.Fatalf("got %v, %v", b, ok)
+	}
+	<-put
+	q.close()
+	if b, ok := q.get(); !ok || b.events[0].Value != 2 {
+		t.Fatalf("got %v, %v after close", b, ok)
+	}
+	if _, ok := q.get(); ok {
+		t.Error("got a batch from a closed, empty queue")
+	}
+}
+
+func TestStats(t *testing.T) {
+	clock := useFakeClock(t)
+	dev, w := newPipeDevice(t)
+
+	writeEvents(t, w,
+		newEvent(5, EV_REL, REL_X, 1),
+		newEvent(5, EV_SYN, SYN_DROPPED, 0),
+		newEvent(6, EV_SYN, SYN_REPORT, 0))
+	if _, err := dev.Read(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(500 * time.Millisecond)
+	writeEvents(t, w, newEvent(7, EV_REL, REL_X, 1))
+	if _, err := dev.ReadOne(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := dev.Stats()
+	if stats.Events != 4 || stats.Bytes != uint64(4*eventsize) || stats.Overruns != 1 {
+		t.Errorf("got %+v", stats)
+	}
+	if !stats.LastEvent.Equal(time.Unix(7, 0)) {
+		t.Errorf("got last event at %v", stats.LastEvent)
+	}
+	if stats.Rate != 0 {
+		t.Errorf("got rate %v before a second has passed", stats.Rate)
+	}
+
+	// The device goes quiet: 4 events in 2 seconds.
+	clock.Advance(1500 * time.Millisecond)
+	if rate := dev.Stats().Rate; rate != 2 {
+		t.Errorf("got rate %v, want 2", rate)
+	}
+}
@@ -1482,6 +1482,7 @@ var SW = map[int]string{}
 var MSC = map[int]string{}
 var LED = map[int]string{}
 var BTN = map[int]string{}
+var KEYBTN = map[int]string{}
 var REP = map[int]string{}
 var SND = map[int]string{}
 var ID = map[int]string{}
@@ -1491,7 +1492,7 @@ var SYN = map[int]string{}
 var FF = map[int]string{}
 
 var ByEventType = map[int]map[int]string{
-	EV_KEY: KEY,
+	EV_KEY: KEYBTN,
 	EV_ABS: ABS,
 	EV_REL: REL,
 	EV_SW:  SW,
@@ -1507,31 +1508,41 @@ func init() {
 	for code, value := range ecodes {
 		switch {
 		case strings.HasPrefix(code, "KEY"):
-			KEY[value] = code
+			add_code_name(KEY, value, code)
 		case strings.HasPrefix(code, "ABS"):
-			ABS[value] = code
+			add_code_name(ABS, value, code)
 		case strings.HasPrefix(code, "REL"):
-			REL[value] = code
+			add_code_name(REL, value, code)
 		case strings.HasPrefix(code, "SW"):
-			SW[value] = code
+			add_code_name(SW, value, code)
 		case strings.HasPrefix(code, "MSC"):
-			MSC[value] = code
+			add_code_name(MSC, value, code)
 		case strings.HasPrefix(code, "LED"):
-			LED[value] = code
+			add_code_name(LED, value, code)
 		case strings.HasPrefix(code, "BTN"):
-			BTN[value] = code
+			add_code_name(BTN, value, code)
+		case strings.HasPrefix(code, "REP"):
+			add_code_name(REP, value, code)
 		case strings.HasPrefix(code, "SND"):
-			SND[value] = code
+			add_code_name(SND, value, code)
 		case strings.HasPrefix(code, "ID"):
-			ID[value] = code
+			add_code_name(ID, value, code)
 		case strings.HasPrefix(code, "EV"):
-			EV[value] = code
+			add_code_name(EV, value, code)
 		case strings.HasPrefix(code, "BUS"):
-			BUS[value] = code
+			add_code_name(BUS, value, code)
 		case strings.HasPrefix(code, "SYN"):
-			SYN[value] = code
+			add_code_name(SYN, value, code)
 		case strings.HasPrefix(code, "FF"):
-			FF[value] = code
+			add_code_name(FF, value, code)
 		}
 	}
+
+	// Buttons share the EV_KEY code space with keys.
+	for value, code := range KEY {
+		add_code_name(KEYBTN, value, code)
+	}
+	for value, code := range BTN {
+		add_code_name(KEYBTN, value, code)
+	}
 }
@@ -1,6 +1,15 @@
 package evdev
 
-import "testing"
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"syscall"
+	"testing"
+	"testing/iotest"
+	"time"
+)
 
 func TestAccess(t *testing.T) {
 	if KEY_A != ecodes["KEY_A"] {
@@ -19,3 +28,861 @@ func TestAccess(t *testing.T) {
 		t.Error()
 	}
 }
+
+func TestParsePhys(t *testing.T) {
+	tests := []struct {
+		phys string
+		want PhysInfo
+	}{
+		{"usb-0000:00:12.0-2/input0", PhysInfo{"usb-0000:00:12.0-2/input0", "usb", "0000:00:12.0", "2", 0}},
+		{"usb-0000:00:14.0-1.4.3/input1", PhysInfo{"usb-0000:00:14.0-1.4.3/input1", "usb", "0000:00:14.0", "1.4.3", 1}},
+		{"isa0060/serio0/input0", PhysInfo{"isa0060/serio0/input0", "isa", "isa0060", "serio0", 0}},
+		{"00:1a:7d:da:71:13", PhysInfo{"00:1a:7d:da:71:13", "bluetooth", "00:1a:7d:da:71:13", "", -1}},
+		{"gpio-keys/input0", PhysInfo{"gpio-keys/input0", "", "", "", 0}},
+		{"", PhysInfo{"", "", "", "", -1}},
+	}
+
+	for _, tt := range tests {
+		if got := ParsePhys(tt.phys); got != tt.want {
+			t.Errorf("ParsePhys(%q) = %+v, want %+v", tt.phys, got, tt.want)
+		}
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read(p []byte) (int, error) { return 0, r.err }
+
+func TestReadEventsError(t *testing.T) {
+	events, err := read_events(errReader{io.ErrUnexpectedEOF}, 16)
+	if err == nil || len(events) != 0 {
+		t.Errorf("got %d events, err %v; want none and an error", len(events), err)
+	}
+
+	ev, err := read_one_event(errReader{io.ErrUnexpectedEOF})
+	if err == nil || ev != nil {
+		t.Errorf("got %v, err %v; want nil and an error", ev, err)
+	}
+}
+
+func TestAbsFilter(t *testing.T) {
+	f := NewAbsFilter()
+	f.SetAxis(ABS_X, 4, 1)
+
+	abs := func(code uint16, value int32) InputEvent {
+		return InputEvent{Type: EV_ABS, Code: code, Value: value}
+	}
+
+	in := []InputEvent{
+		abs(ABS_X, 100), // first value always passes
+		abs(ABS_X, 103), // within fuzz
+		abs(ABS_X, 96),  // within fuzz
+		abs(ABS_Y, 101), // axis not configured
+		abs(ABS_X, 120), // real movement
+		{Type: EV_SYN, Code: SYN_REPORT},
+	}
+	want := []int32{100, 101, 120, 0}
+
+	out := f.Transform(in)
+	if len(out) != len(want) {
+		t.Fatalf("got %d events, want %d", len(out), len(want))
+	}
+	for i := range out {
+		if out[i].Value != want[i] {
+			t.Errorf("event %d value %d, want %d", i, out[i].Value, want[i])
+		}
+	}
+}
+
+func TestAbsFilterSmoothing(t *testing.T) {
+	f := NewAbsFilter()
+	f.SetAxis(ABS_X, 0, 0.5)
+
+	out := f.Transform([]InputEvent{
+		{Type: EV_ABS, Code: ABS_X, Value: 0},
+		{Type: EV_ABS, Code: ABS_X, Value: 100},
+		{Type: EV_ABS, Code: ABS_X, Value: 100},
+	})
+
+	if len(out) != 3 || out[1].Value != 50 || out[2].Value != 75 {
+		t.Errorf("got %v", out)
+	}
+}
+
+func TestAbsFilterMultitouch(t *testing.T) {
+	f := NewAbsFilter()
+	f.SetAxis(ABS_MT_SLOT, 4, 0.5)
+	f.SetAxis(ABS_MT_TRACKING_ID, 4, 0.5)
+	f.SetAxis(ABS_MT_POSITION_X, 4, 1)
+
+	abs := func(code uint16, value int32) InputEvent {
+		return InputEvent{Type: EV_ABS, Code: code, Value: value}
+	}
+
+	in := []InputEvent{
+		abs(ABS_MT_SLOT, 0),
+		abs(ABS_MT_TRACKING_ID, 10),
+		abs(ABS_MT_POSITION_X, 100),
+		abs(ABS_MT_SLOT, 1), // second finger within fuzz of the first
+		abs(ABS_MT_TRACKING_ID, 11),
+		abs(ABS_MT_POSITION_X, 102),
+		abs(ABS_MT_SLOT, 0),
+		abs(ABS_MT_POSITION_X, 103), // within fuzz of slot 0
+		abs(ABS_MT_TRACKING_ID, -1),
+		abs(ABS_MT_SLOT, 1),
+		abs(ABS_MT_TRACKING_ID, -1), // second lift must not be dropped
+		abs(ABS_MT_SLOT, 0),
+		abs(ABS_MT_TRACKING_ID, 12),
+		abs(ABS_MT_POSITION_X, 101), // new contact starts afresh
+	}
+	want := []InputEvent{
+		abs(ABS_MT_SLOT, 0),
+		abs(ABS_MT_TRACKING_ID, 10),
+		abs(ABS_MT_POSITION_X, 100),
+		abs(ABS_MT_SLOT, 1),
+		abs(ABS_MT_TRACKING_ID, 11),
+		abs(ABS_MT_POSITION_X, 102),
+		abs(ABS_MT_SLOT, 0),
+		abs(ABS_MT_TRACKING_ID, -1),
+		abs(ABS_MT_SLOT, 1),
+		abs(ABS_MT_TRACKING_ID, -1),
+		abs(ABS_MT_SLOT, 0),
+		abs(ABS_MT_TRACKING_ID, 12),
+		abs(ABS_MT_POSITION_X, 101),
+	}
+
+	out := f.Transform(in)
+	if len(out) != len(want) {
+		t.Fatalf("got %v, want %v", out, want)
+	}
+	for i := range out {
+		if out[i] != want[i] {
+			t.Errorf("event %d is %v, want %v", i, out[i], want[i])
+		}
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	in := Frame{
+		Seq:    42,
+		Device: "usb-0000:00:12.0-2/input0",
+		Events: []InputEvent{
+			{Time: syscall.NsecToTimeval(1347905437435795000), Type: EV_REL, Code: REL_X, Value: -5},
+			{Time: syscall.NsecToTimeval(1347905437435795000), Type: EV_REL, Code: REL_Y, Value: 3},
+			{Time: syscall.NsecToTimeval(1347905437435795000), Type: EV_SYN, Code: SYN_REPORT},
+		},
+	}
+
+	b, err := in.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Frame
+	if err := out.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", out, in)
+	}
+
+	if err := out.UnmarshalBinary(b[:len(b)-1]); err == nil {
+		t.Error("truncated frame decoded without error")
+	}
+}
+
+func TestMaxCode(t *testing.T) {
+	tests := map[int]int{
+		EV_SYN: SYN_MAX,
+		EV_KEY: KEY_MAX,
+		EV_REL: REL_MAX,
+		EV_ABS: ABS_MAX,
+		EV_SW:  SW_MAX,
+		EV_LED: LED_MAX,
+		EV_FF:  FF_MAX,
+		EV_PWR: -1,
+	}
+	for evtype, want := range tests {
+		if got := MaxCode(evtype); got != want {
+			t.Errorf("MaxCode(%s) = %d, want %d", EV[evtype], got, want)
+		}
+	}
+}
+
+func TestCodeName(t *testing.T) {
+	if got := CodeName(EV_KEY, KEY_A); got != "KEY_A" {
+		t.Errorf("CodeName(EV_KEY, KEY_A) = %q", got)
+	}
+	if got := CodeName(EV_KEY, 0x300); got != "UNKNOWN(0x300)" {
+		t.Errorf("CodeName(EV_KEY, 0x300) = %q", got)
+	}
+	if got := CodeName(EV_PWR, 0); got != "UNKNOWN(0x0)" {
+		t.Errorf("CodeName(EV_PWR, 0) = %q", got)
+	}
+	if got := TypeName(0x1e); got != "UNKNOWN(0x1e)" {
+		t.Errorf("TypeName(0x1e) = %q", got)
+	}
+}
+
+func TestInputEventString(t *testing.T) {
+	tests := map[InputEvent]string{
+		{Time: syscall.Timeval{Sec: 1, Usec: 5}, Type: EV_REL, Code: REL_Y, Value: 2}: "event at 1.5, code REL_Y, type EV_REL, val 02",
+		{Type: EV_KEY, Code: 0x300, Value: 1}:                                         "event at 0.0, code UNKNOWN(0x300), type EV_KEY, val 01",
+	}
+	for ev, want := range tests {
+		if got := ev.String(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestReadHangup(t *testing.T) {
+	if _, err := read_events(errReader{syscall.ENODEV}, 16); err != ErrDeviceHangup {
+		t.Errorf("read_events: got %v, want ErrDeviceHangup", err)
+	}
+	if _, err := read_one_event(errReader{syscall.ENODEV}); err != ErrDeviceHangup {
+		t.Errorf("read_one_event: got %v, want ErrDeviceHangup", err)
+	}
+}
+
+func TestHIDUsage(t *testing.T) {
+	tests := []struct {
+		code        int
+		page, usage uint16
+	}{
+		{KEY_A, HIDPageKeyboard, 0x04},
+		{KEY_ENTER, HIDPageKeyboard, 0x28},
+		{KEY_LEFTCTRL, HIDPageKeyboard, 0xe0},
+		{KEY_F12, HIDPageKeyboard, 0x45},
+		{KEY_VOLUMEUP, HIDPageConsumer, 0xe9},
+		{KEY_PLAYPAUSE, HIDPageConsumer, 0xcd},
+	}
+
+	for _, tt := range tests {
+		page, usage, ok := KeyToHIDUsage(tt.code)
+		if !ok || page != tt.page || usage != tt.usage {
+			t.Errorf("KeyToHIDUsage(%s) = %#x, %#x, %v", KEY[tt.code], page, usage, ok)
+		}
+		if code, ok := HIDUsageToKey(tt.page, tt.usage); !ok || code != tt.code {
+			t.Errorf("HIDUsageToKey(%#x, %#x) = %d, %v", tt.page, tt.usage, code, ok)
+		}
+	}
+
+	// The keyboard page volume usage maps to the same key.
+	if code, ok := HIDUsageToKey(HIDPageKeyboard, 0x80); !ok || code != KEY_VOLUMEUP {
+		t.Errorf("HIDUsageToKey(keyboard, 0x80) = %d, %v", code, ok)
+	}
+	if _, _, ok := KeyToHIDUsage(BTN_LEFT); ok {
+		t.Error("BTN_LEFT has no HID keyboard usage")
+	}
+}
+
+func TestKeyEventsWithScan(t *testing.T) {
+	events := []InputEvent{
+		{Type: EV_MSC, Code: MSC_SCAN, Value: 0x70004},
+		{Type: EV_KEY, Code: KEY_A, Value: 1},
+		{Type: EV_SYN, Code: SYN_REPORT},
+		{Type: EV_KEY, Code: KEY_B, Value: 1},
+		{Type: EV_SYN, Code: SYN_REPORT},
+		{Type: EV_MSC, Code: MSC_SCAN, Value: 0x70005},
+		{Type: EV_SYN, Code: SYN_REPORT},
+		{Type: EV_KEY, Code: KEY_C, Value: 0},
+	}
+
+	kevs := KeyEventsWithScan(events)
+	if len(kevs) != 3 {
+		t.Fatalf("got %d key events, want 3", len(kevs))
+	}
+	if k := kevs[0]; k.Scancode != KEY_A || !k.HasMscScan || k.MscScan != 0x70004 || k.State != KeyDown {
+		t.Errorf("KEY_A: %+v", k)
+	}
+	// Scan values do not carry over to other key events or frames.
+	if k := kevs[1]; k.Scancode != KEY_B || k.HasMscScan {
+		t.Errorf("KEY_B: %+v", k)
+	}
+	if k := kevs[2]; k.Scancode != KEY_C || k.HasMscScan || k.State != KeyUp {
+		t.Errorf("KEY_C: %+v", k)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	dropSyn := TransformFunc(func(events []InputEvent) []InputEvent {
+		out := events[:0]
+		for _, ev := range events {
+			if ev.Type != EV_SYN {
+				out = append(out, ev)
+			}
+		}
+		return out
+	})
+	double := TransformFunc(func(events []InputEvent) []InputEvent {
+		for i := range events {
+			events[i].Value *= 2
+		}
+		return events
+	})
+
+	f := NewAbsFilter()
+	f.SetAxis(ABS_X, 5, 1)
+
+	p := NewPipeline(f, NewPipeline(dropSyn, double))
+	out := p.Transform([]InputEvent{
+		{Type: EV_ABS, Code: ABS_X, Value: 10},
+		{Type: EV_ABS, Code: ABS_X, Value: 12},
+		{Type: EV_SYN, Code: SYN_REPORT},
+		{Type: EV_ABS, Code: ABS_X, Value: 30},
+	})
+
+	if len(out) != 2 || out[0].Value != 20 || out[1].Value != 60 {
+		t.Errorf("got %v", out)
+	}
+	if out := NewPipeline().Transform(nil); len(out) != 0 {
+		t.Errorf("empty pipeline returned %v", out)
+	}
+}
+
+func TestParseKeySequence(t *testing.T) {
+	press := func(codes ...int) []InputEvent {
+		events := make([]InputEvent, 0)
+		for _, code := range codes {
+			events = append(events, key_event(code, KeyDown)...)
+		}
+		return events
+	}
+	release := func(codes ...int) []InputEvent {
+		events := make([]InputEvent, 0)
+		for _, code := range codes {
+			events = append(events, key_event(code, KeyUp)...)
+		}
+		return events
+	}
+	seq := func(parts ...[]InputEvent) []InputEvent {
+		events := make([]InputEvent, 0)
+		for _, part := range parts {
+			events = append(events, part...)
+		}
+		return events
+	}
+
+	tests := []struct {
+		spec string
+		want []InputEvent
+	}{
+		{"ctrl+alt+t", seq(
+			press(KEY_LEFTCTRL, KEY_LEFTALT, KEY_T),
+			release(KEY_T, KEY_LEFTALT, KEY_LEFTCTRL))},
+		{"t+ctrl", seq(press(KEY_LEFTCTRL, KEY_T), release(KEY_T, KEY_LEFTCTRL))},
+		{"h  e l l o", seq(
+			press(KEY_H), release(KEY_H),
+			press(KEY_E), release(KEY_E),
+			press(KEY_L), release(KEY_L),
+			press(KEY_L), release(KEY_L),
+			press(KEY_O), release(KEY_O))},
+		{"Shift+1 KEY_ENTER", seq(
+			press(KEY_LEFTSHIFT, KEY_1), release(KEY_1, KEY_LEFTSHIFT),
+			press(KEY_ENTER), release(KEY_ENTER))},
+		{"H i !", seq(
+			press(KEY_LEFTSHIFT, KEY_H), release(KEY_H, KEY_LEFTSHIFT),
+			press(KEY_I), release(KEY_I),
+			press(KEY_LEFTSHIFT, KEY_1), release(KEY_1, KEY_LEFTSHIFT))},
+		{"ctrl+shift+T", seq(
+			press(KEY_LEFTCTRL, KEY_LEFTSHIFT, KEY_T),
+			release(KEY_T, KEY_LEFTSHIFT, KEY_LEFTCTRL))},
+	}
+	for _, test := range tests {
+		got, err := ParseKeySequence(test.spec)
+		if err != nil {
+			t.Errorf("%q: %s", test.spec, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v\nwant %v", test.spec, got, test.want)
+		}
+	}
+
+	for _, spec := range []string{"ctrl+bogus", "a b+", "KEY_MAX"} {
+		if _, err := ParseKeySequence(spec); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+}
+
+func TestClassifyAxes(t *testing.T) {
+	// An Xbox style gamepad.
+	xbox := []int{ABS_X, ABS_Y, ABS_Z, ABS_RX, ABS_RY, ABS_RZ, ABS_HAT0X, ABS_HAT0Y}
+	want := map[int]AxisRole{
+		ABS_X: LeftStickX, ABS_Y: LeftStickY,
+		ABS_RX: RightStickX, ABS_RY: RightStickY,
+		ABS_Z: TriggerLeft, ABS_RZ: TriggerRight,
+		ABS_HAT0X: HatX, ABS_HAT0Y: HatY,
+	}
+	if got := ClassifyAxes(xbox, VendorProduct{0x45e, 0x28e}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+
+	// A generic HID gamepad with the right stick on Z/RZ.
+	generic := []int{ABS_X, ABS_Y, ABS_Z, ABS_RZ, ABS_GAS, ABS_BRAKE, ABS_HAT0X, ABS_HAT0Y, ABS_MISC}
+	got := ClassifyAxes(generic, VendorProduct{})
+	if got[ABS_Z] != RightStickX || got[ABS_RZ] != RightStickY || got[ABS_BRAKE] != TriggerLeft || got[ABS_GAS] != TriggerRight {
+		t.Errorf("got %v", got)
+	}
+	if _, ok := got[ABS_MISC]; ok {
+		t.Errorf("ABS_MISC classified as %s", got[ABS_MISC])
+	}
+
+	// An override swapping the sticks of one model.
+	id := VendorProduct{0x1234, 0x5678}
+	AxisLayoutOverrides[id] = map[int]AxisRole{ABS_X: RightStickX, ABS_RX: LeftStickX}
+	defer delete(AxisLayoutOverrides, id)
+	got = ClassifyAxes(xbox, id)
+	if got[ABS_X] != RightStickX || got[ABS_RX] != LeftStickX || got[ABS_Y] != LeftStickY {
+		t.Errorf("override not applied: %v", got)
+	}
+}
+
+func TestX11Keysyms(t *testing.T) {
+	// The evdev X driver reports KEY_ESC (1) as keycode 9.
+	if KeyToX11Keycode(KEY_ESC) != 9 || X11KeycodeToKey(9) != KEY_ESC {
+		t.Error("wrong keycode offset")
+	}
+
+	tests := []struct {
+		code   int
+		keysym uint32
+	}{
+		{KEY_A, 'a'},
+		{KEY_Z, 'z'},
+		{KEY_0, '0'},
+		{KEY_ENTER, 0xff0d},
+		{KEY_F12, 0xffc9},
+		{KEY_LEFTCTRL, 0xffe3},
+		{KEY_KP7, 0xffb7},
+	}
+	for _, test := range tests {
+		if keysym, ok := KeyToKeysym(test.code); !ok || keysym != test.keysym {
+			t.Errorf("%s: got keysym %#x, %v", CodeName(EV_KEY, test.code), keysym, ok)
+		}
+		if code, ok := KeysymToKey(test.keysym); !ok || code != test.code {
+			t.Errorf("keysym %#x: got %d, %v", test.keysym, code, ok)
+		}
+	}
+
+	if code, ok := KeysymToKey('Q'); !ok || code != KEY_Q {
+		t.Errorf("Q: got %d, %v", code, ok)
+	}
+	if _, ok := KeyToKeysym(KEY_VOLUMEUP); ok {
+		t.Error("KEY_VOLUMEUP has a keysym")
+	}
+}
+
+func TestReaderDevice(t *testing.T) {
+	recorded := make([]InputEvent, 0)
+	for i := 0; i < 20; i++ {
+		recorded = append(recorded,
+			InputEvent{Time: syscall.NsecToTimeval(int64(i) * 1e7), Type: EV_REL, Code: REL_X, Value: int32(i - 10)},
+			InputEvent{Time: syscall.NsecToTimeval(int64(i) * 1e7), Type: EV_SYN, Code: SYN_REPORT})
+	}
+	var stream bytes.Buffer
+	binary.Write(&stream, native_endian, recorded)
+	raw := stream.Bytes()
+
+	// A byte at a time, so that events arrive in pieces.
+	var src EventSource = NewReaderDevice(iotest.OneByteReader(bytes.NewReader(raw)))
+	got := make([]InputEvent, 0)
+	first, err := src.ReadOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, *first)
+	for {
+		events, err := src.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, events...)
+	}
+	if !reflect.DeepEqual(got, recorded) {
+		t.Errorf("read back %d events that differ from the %d recorded", len(got), len(recorded))
+	}
+
+	truncated := NewReaderDevice(bytes.NewReader(raw[:len(raw)-3]))
+	for err == nil {
+		_, err = truncated.Read()
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v for a truncated stream", err)
+	}
+}
+
+func TestButtonNames(t *testing.T) {
+	tests := []struct {
+		evtype, code int
+		name         string
+	}{
+		{EV_KEY, BTN_LEFT, "BTN_LEFT"},
+		{EV_KEY, BTN_SOUTH, "BTN_SOUTH"},
+		{EV_KEY, BTN_NORTH, "BTN_NORTH"},
+		{EV_KEY, BTN_TRIGGER, "BTN_TRIGGER"},
+		{EV_KEY, BTN_0, "BTN_0"},
+		{EV_KEY, BTN_TOOL_PEN, "BTN_TOOL_PEN"},
+		{EV_KEY, BTN_TRIGGER_HAPPY1, "BTN_TRIGGER_HAPPY1"},
+		{EV_KEY, KEY_MUTE, "KEY_MUTE"},
+		{EV_KEY, KEY_COFFEE, "KEY_COFFEE"},
+		{EV_REP, REP_PERIOD, "REP_PERIOD"},
+		{EV_FF, FF_RUMBLE, "FF_RUMBLE"},
+	}
+	for _, test := range tests {
+		if got := CodeName(test.evtype, test.code); got != test.name {
+			t.Errorf("CodeName(%s, %d) = %q, want %q", TypeName(test.evtype), test.code, got, test.name)
+		}
+	}
+
+	// The BTN and KEY tables keep their own ranges.
+	if BTN[BTN_SOUTH] != "BTN_SOUTH" || KEY[BTN_SOUTH] != "" {
+		t.Errorf("BTN[BTN_SOUTH] = %q, KEY[BTN_SOUTH] = %q", BTN[BTN_SOUTH], KEY[BTN_SOUTH])
+	}
+}
+
+func TestKeymap(t *testing.T) {
+	swap := Keymap{KEY_CAPSLOCK: KEY_ESC, KEY_ESC: KEY_CAPSLOCK}
+	events := swap.Transform([]InputEvent{
+		{Type: EV_KEY, Code: KEY_CAPSLOCK, Value: 1},
+		{Type: EV_KEY, Code: KEY_ESC, Value: 1},
+		{Type: EV_KEY, Code: KEY_A, Value: 1},
+		{Type: EV_MSC, Code: KEY_ESC}, // not a key event, code 1 is MSC_SERIAL
+	})
+	want := []uint16{KEY_ESC, KEY_CAPSLOCK, KEY_A, KEY_ESC}
+	for i, ev := range events {
+		if ev.Code != want[i] {
+			t.Errorf("event %d: got code %d, want %d", i, ev.Code, want[i])
+		}
+	}
+
+	p := NewPipeline(swap, TransformFunc(func(events []InputEvent) []InputEvent {
+		return events[:1]
+	}))
+	if out := p.Transform([]InputEvent{{Type: EV_KEY, Code: KEY_ESC}}); len(out) != 1 || out[0].Code != KEY_CAPSLOCK {
+		t.Errorf("got %v from pipeline", out)
+	}
+}
+
+func TestReadEmpty(t *testing.T) {
+	// A source that is gone but reports no error.
+	empty := errReader{nil}
+
+	if events, err := read_events(empty, 16); err != io.EOF || events != nil {
+		t.Errorf("read_events: got %v, %v", events, err)
+	}
+	if ev, err := read_one_event(empty); err != io.EOF || ev != nil {
+		t.Errorf("read_one_event: got %v, %v", ev, err)
+	}
+	var b event_buffer
+	if events, err := b.read(empty, 16); err != io.EOF || events != nil {
+		t.Errorf("event_buffer.read: got %v, %v", events, err)
+	}
+}
+
+func TestDecodeByteOrder(t *testing.T) {
+	want := InputEvent{Type: EV_ABS, Code: ABS_Y, Value: -300}
+	want.Time.Sec, want.Time.Usec = 1700000000, 250000
+
+	saved := native_endian
+	defer func() { native_endian = saved }()
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		native_endian = order
+		var raw bytes.Buffer
+		binary.Write(&raw, order, want)
+
+		var got InputEvent
+		decode_event(raw.Bytes(), &got)
+		if got != want {
+			t.Errorf("%v: got %v, want %v", order, &got, &want)
+		}
+		b := make([]byte, eventsize)
+		encode_event(b, &want)
+		if !bytes.Equal(b, raw.Bytes()) {
+			t.Errorf("%v: encoded % x, want % x", order, b, raw.Bytes())
+		}
+	}
+}
+
+func TestRelCoalescer(t *testing.T) {
+	at := func(ms int64, evtype, code uint16, value int32) InputEvent {
+		return InputEvent{Time: syscall.NsecToTimeval(ms * 1e6), Type: evtype, Code: code, Value: value}
+	}
+	events := []InputEvent{
+		at(0, EV_REL, REL_X, 1),
+		at(0, EV_REL, REL_Y, 2),
+		at(0, EV_REL, REL_X, 3),
+		at(0, EV_SYN, SYN_REPORT, 0),
+		at(1, EV_REL, REL_X, 4),
+		at(1, EV_SYN, SYN_REPORT, 0),
+		at(2, EV_KEY, BTN_LEFT, 1),
+		at(2, EV_SYN, SYN_REPORT, 0),
+		at(3, EV_REL, REL_WHEEL, -1),
+		at(3, EV_SYN, SYN_REPORT, 0),
+		at(9, EV_REL, REL_WHEEL, -1),
+		at(9, EV_SYN, SYN_REPORT, 0),
+		at(10, EV_REL, REL_X, 5),
+	}
+
+	within := NewRelCoalescer(0).Transform(events)
+	if len(within) != 12 || within[0].Value != 4 || within[1].Code != REL_Y {
+		t.Errorf("frame only: got %v", within)
+	}
+
+	got := NewRelCoalescer(5 * time.Millisecond).Transform(events)
+	want := []InputEvent{
+		at(0, EV_REL, REL_X, 8),
+		at(0, EV_REL, REL_Y, 2),
+		at(1, EV_SYN, SYN_REPORT, 0),
+		at(2, EV_KEY, BTN_LEFT, 1),
+		at(2, EV_SYN, SYN_REPORT, 0),
+		at(3, EV_REL, REL_WHEEL, -1),
+		at(3, EV_SYN, SYN_REPORT, 0),
+		at(9, EV_REL, REL_WHEEL, -1), // outside the window
+		at(9, EV_SYN, SYN_REPORT, 0),
+		at(10, EV_REL, REL_X, 5),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	if events[0].Value != 1 {
+		t.Error("input events were modified")
+	}
+}
+
+func TestReadEventsByteCount(t *testing.T) {
+	// Zero timestamps are legitimate (e.g. from a fresh monotonic clock)
+	// and must not end the batch.
+	want := []InputEvent{
+		{Type: EV_KEY, Code: KEY_A, Value: 1},
+		{Type: EV_SYN, Code: SYN_REPORT},
+	}
+	var raw bytes.Buffer
+	binary.Write(&raw, native_endian, want)
+
+	events, err := read_events(bytes.NewReader(raw.Bytes()), 16)
+	if err != nil || !reflect.DeepEqual(events, want) {
+		t.Errorf("got %v, %v, want %v", events, err, want)
+	}
+
+	// A short read returns the events read so far, completing a partial
+	// event.
+	r := iotest.OneByteReader(bytes.NewReader(raw.Bytes()))
+	for i := range want {
+		events, err = read_events(r, 16)
+		if err != nil || len(events) != 1 || events[0] != want[i] {
+			t.Errorf("event %d: got %v, %v", i, events, err)
+		}
+	}
+
+	// A read cut off within an event fails instead of decoding garbage.
+	truncated := iotest.OneByteReader(bytes.NewReader(raw.Bytes()[:eventsize/2]))
+	if events, err = read_events(truncated, 16); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, %v, want io.ErrUnexpectedEOF", events, err)
+	}
+
+	// An interrupted read reports the error and no events.
+	if events, err = read_events(errReader{syscall.EINTR}, 16); err != syscall.EINTR || events != nil {
+		t.Errorf("got %v, %v, want EINTR", events, err)
+	}
+}
+
+func TestBufferedReader(t *testing.T) {
+	stream := []InputEvent{
+		{Type: EV_ABS, Code: ABS_MT_SLOT, Value: 1},
+		{Type: EV_ABS, Code: ABS_MT_POSITION_X, Value: 100},
+		{Type: EV_SYN, Code: SYN_REPORT},
+		{Type: EV_KEY, Code: BTN_TOUCH, Value: 0},
+	}
+	var raw bytes.Buffer
+	binary.Write(&raw, native_endian, stream)
+	// A byte at a time, so that lookahead needs several reads.
+	r := NewBufferedReader(NewReaderDevice(iotest.OneByteReader(bytes.NewReader(raw.Bytes()))))
+
+	frame, err := r.PeekFrame()
+	if err != nil || !reflect.DeepEqual(frame, stream[:3]) {
+		t.Fatalf("got %v, %v", frame, err)
+	}
+	if ev, err := r.Peek(); err != nil || *ev != stream[0] {
+		t.Fatalf("got %v, %v", ev, err)
+	}
+
+	first, err := r.ReadOne()
+	if err != nil || *first != stream[0] {
+		t.Fatalf("got %v, %v", first, err)
+	}
+	r.Unread(*first)
+	events, err := r.Read()
+	if err != nil || !reflect.DeepEqual(events, stream[:3]) {
+		t.Fatalf("got %v, %v after Unread", events, err)
+	}
+	if ev, err := r.ReadOne(); err != nil || *ev != stream[3] {
+		t.Fatalf("got %v, %v", ev, err)
+	}
+	if _, err := r.Peek(); err != io.EOF {
+		t.Errorf("got %v at the end of the stream", err)
+	}
+}
+
+func TestEventTimestamps(t *testing.T) {
+	a := InputEvent{Time: syscall.Timeval{Sec: 1700000000, Usec: 250000}}
+	b := InputEvent{Time: syscall.Timeval{Sec: 1700000001, Usec: 0}}
+
+	if ts := a.Timestamp(); !ts.Equal(time.Unix(1700000000, 250e6)) {
+		t.Errorf("got timestamp %v", ts)
+	}
+	if d := a.SinceBoot(); d != 1700000000*time.Second+250*time.Millisecond {
+		t.Errorf("got %s", d)
+	}
+	if !a.Before(&b) || a.After(&b) || !b.After(&a) || a.Before(&a) {
+		t.Error("wrong order")
+	}
+	if d := b.Sub(&a); d != 750*time.Millisecond {
+		t.Errorf("got interval %s", d)
+	}
+}
+
+func TestCategorize(t *testing.T) {
+	events := []InputEvent{
+		{Type: EV_KEY, Code: KEY_A, Value: 2},
+		{Type: EV_REL, Code: REL_WHEEL, Value: -1},
+		{Type: EV_ABS, Code: ABS_MT_POSITION_X, Value: 300},
+		{Type: EV_SYN, Code: SYN_DROPPED},
+		{Type: EV_SW, Code: SW_LID, Value: 1},
+		{Type: EV_MSC, Code: MSC_SCAN, Value: 30},
+	}
+
+	if kev, ok := Categorize(&events[0]).(*KeyEvent); !ok || kev.State != KeyHold || kev.Scancode != KEY_A {
+		t.Errorf("key: got %v", Categorize(&events[0]))
+	}
+	if rev, ok := Categorize(&events[1]).(*RelEvent); !ok || rev.Axis != "REL_WHEEL" {
+		t.Errorf("rel: got %v", Categorize(&events[1]))
+	}
+	if aev, ok := Categorize(&events[2]).(*AbsEvent); !ok || aev.Axis != "ABS_MT_POSITION_X" || aev.Event.Value != 300 {
+		t.Errorf("abs: got %v", Categorize(&events[2]))
+	}
+	if sev, ok := Categorize(&events[3]).(*SynEvent); !ok || sev.Name != "SYN_DROPPED" {
+		t.Errorf("syn: got %v", Categorize(&events[3]))
+	}
+	if swev, ok := Categorize(&events[4]).(*SwitchEvent); !ok || swev.Switch != "SW_LID" || !swev.On {
+		t.Errorf("switch: got %v", Categorize(&events[4]))
+	}
+	if ev, ok := Categorize(&events[5]).(*InputEvent); !ok || ev != &events[5] {
+		t.Errorf("msc: got %v", Categorize(&events[5]))
+	}
+}
+
+func TestFilters(t *testing.T) {
+	events := []InputEvent{
+		{Type: EV_MSC, Code: MSC_SCAN, Value: 30},
+		{Type: EV_KEY, Code: KEY_A, Value: 1},
+		{Type: EV_KEY, Code: KEY_A, Value: 2},
+		{Type: EV_KEY, Code: KEY_B, Value: 1},
+		{Type: EV_KEY, Code: KEY_A, Value: 0},
+		{Type: EV_SYN, Code: SYN_REPORT},
+	}
+	codes := func(f Filter) []uint16 {
+		out := make([]uint16, 0)
+		for _, ev := range Select(f).Transform(events) {
+			out = append(out, ev.Code)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []uint16
+	}{
+		{"ByType", ByType(EV_KEY), []uint16{KEY_A, KEY_A, KEY_B, KEY_A}},
+		{"ByCode", AllOf(ByType(EV_KEY), ByCode(KEY_B)), []uint16{KEY_B}},
+		{"KeyDownOnly", KeyDownOnly(), []uint16{KEY_A, KEY_B}},
+		{"AnyOf", AnyOf(KeyDownOnly(), ByType(EV_SYN)), []uint16{KEY_A, KEY_B, SYN_REPORT}},
+		{"Not", Not(ByType(EV_KEY, EV_SYN)), []uint16{MSC_SCAN}},
+	}
+	for _, test := range tests {
+		if got := codes(test.filter); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPipelineRun(t *testing.T) {
+	stream := []InputEvent{
+		{Type: EV_MSC, Code: MSC_SCAN, Value: 30},
+		{Type: EV_KEY, Code: KEY_CAPSLOCK, Value: 1},
+		{Type: EV_SYN, Code: SYN_REPORT},
+	}
+	var raw bytes.Buffer
+	binary.Write(&raw, native_endian, stream)
+
+	// Turn caps lock into a tap of escape.
+	tap := StageFunc(func(ev InputEvent) []InputEvent {
+		if ev.Type != EV_KEY || ev.Code != KEY_CAPSLOCK {
+			return []InputEvent{ev}
+		}
+		release := ev
+		release.Code, release.Value = KEY_ESC, 0
+		ev.Code = KEY_ESC
+		return []InputEvent{ev, release}
+	})
+	p := NewPipeline(Select(Not(ByType(EV_MSC))), EachEvent(tap))
+
+	var out bytes.Buffer
+	err := p.Run(NewReaderDevice(bytes.NewReader(raw.Bytes())), WriterSink(&out))
+	if err != io.EOF {
+		t.Fatalf("got %v, want io.EOF at the end of the source", err)
+	}
+
+	got, err := NewReaderDevice(&out).Read()
+	want := []InputEvent{
+		{Type: EV_KEY, Code: KEY_ESC, Value: 1},
+		{Type: EV_KEY, Code: KEY_ESC, Value: 0},
+		{Type: EV_SYN, Code: SYN_REPORT},
+	}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, %v, want %v", got, err, want)
+	}
+
+	ch := make(chan []InputEvent, 1)
+	ChanSink(ch).WriteEvents(want)
+	if batch := <-ch; len(batch) != 3 {
+		t.Errorf("got %v from the channel", batch)
+	}
+}
+
+func TestRemapper(t *testing.T) {
+	r := NewRemapper()
+	r.SwapKeys(KEY_LEFTCTRL, KEY_CAPSLOCK)
+	if err := r.BindChord(BTN_SIDE, "ctrl+c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.BindChord(BTN_EXTRA, "ctrl+nosuchkey"); err == nil {
+		t.Error("bound an invalid chord")
+	}
+
+	now := syscall.Timeval{Sec: 5}
+	events := r.Transform([]InputEvent{
+		{Time: now, Type: EV_KEY, Code: KEY_CAPSLOCK, Value: 1},
+		{Time: now, Type: EV_KEY, Code: KEY_LEFTCTRL, Value: 1},
+		{Time: now, Type: EV_KEY, Code: BTN_SIDE, Value: 1},
+		{Time: now, Type: EV_KEY, Code: BTN_SIDE, Value: 0},
+		{Time: now, Type: EV_MSC, Code: KEY_CAPSLOCK}, // other type, same code
+	})
+
+	chord, _ := ParseKeySequence("ctrl+c")
+	want := []InputEvent{
+		{Type: EV_KEY, Code: KEY_LEFTCTRL, Value: 1},
+		{Type: EV_KEY, Code: KEY_CAPSLOCK, Value: 1},
+	}
+	want = append(want, chord[:len(chord)-1]...) // closed by the frame's SYN_REPORT
+	want = append(want, InputEvent{Type: EV_MSC, Code: KEY_CAPSLOCK})
+	for i := range want {
+		want[i].Time = now
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %v\nwant %v", events, want)
+	}
+}
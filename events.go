@@ -1,8 +1,13 @@
 package evdev
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -14,13 +19,364 @@ type InputEvent struct {
 }
 
 // Get a useful description for an input event. Example:
-//   event at 1347905437.435795, code 01, type 02, val 02
+//   event at 1347905437.435795, code REL_Y, type EV_REL, val 02
 func (ev *InputEvent) String() string {
-	return fmt.Sprintf("event at %d.%d, code %02d, type %02d, val %02d",
-		ev.Time.Sec, ev.Time.Usec, ev.Code, ev.Type, ev.Value)
+	return fmt.Sprintf("event at %d.%d, code %s, type %s, val %02d",
+		ev.Time.Sec, ev.Time.Usec, CodeName(int(ev.Type), int(ev.Code)),
+		TypeName(int(ev.Type)), ev.Value)
 }
 
-var eventsize = int(unsafe.Sizeof(InputEvent{}))
+// Return the time at which the event occurred. With the default
+// CLOCK_REALTIME timestamps this is wall clock time; with other clocks
+// (see SetClockID) it counts from that clock's zero point instead of the
+// Unix epoch.
+func (ev *InputEvent) Timestamp() time.Time {
+	return time.Unix(int64(ev.Time.Sec), int64(ev.Time.Usec)*1e3)
+}
+
+// Return the timestamp as a duration. For CLOCK_MONOTONIC and
+// CLOCK_BOOTTIME timestamps this is the time since boot (the latter
+// including time spent suspended).
+func (ev *InputEvent) SinceBoot() time.Duration {
+	return time.Duration(ev.Time.Nano())
+}
+
+// Report whether ev occurred before other.
+func (ev *InputEvent) Before(other *InputEvent) bool {
+	return ev.Time.Nano() < other.Time.Nano()
+}
+
+// Report whether ev occurred after other.
+func (ev *InputEvent) After(other *InputEvent) bool {
+	return ev.Time.Nano() > other.Time.Nano()
+}
+
+// Return the time elapsed from other to ev, e.g. the interval between two
+// reports of a device.
+func (ev *InputEvent) Sub(other *InputEvent) time.Duration {
+	return time.Duration(ev.Time.Nano() - other.Time.Nano())
+}
+
+// Size of the kernel's struct input_event. Its timestamp is two longs,
+// not a struct timeval, so that it stays 32 bits wide on 32-bit platforms
+// even where userspace has a 64-bit time_t (e.g. armv7 or riscv32 with
+// time64). The layout therefore follows the word size alone, independent
+// of how syscall.Timeval is defined; cdefs.go checks it against the C
+// headers.
+const eventsize = int(2*unsafe.Sizeof(uintptr(0)) + 8)
+
+// Return the name of an event type (e.g. "EV_KEY"). Types missing from
+// the name tables get a synthesized name such as "UNKNOWN(0x1e)".
+func TypeName(evtype int) string {
+	if name, ok := EV[evtype]; ok {
+		return name
+	}
+	return unknown_name(evtype)
+}
+
+// Return the name of an event code of the given type (e.g. "KEY_A"). Codes
+// missing from the name tables, such as codes added by newer kernels, get
+// a synthesized name such as "UNKNOWN(0x2ff)".
+func CodeName(evtype, code int) string {
+	if name, ok := ByEventType[evtype][code]; ok {
+		return name
+	}
+	return unknown_name(code)
+}
+
+// Names that are aliases of another name of the same code: range markers,
+// limits and deprecated spellings. They are only used when no other name
+// exists.
+var alias_names = map[string]bool{
+	"KEY_MIN_INTERESTING":   true,
+	"KEY_HANGUEL":           true,
+	"KEY_SCREENLOCK":        true,
+	"KEY_DIRECTION":         true,
+	"KEY_BRIGHTNESS_ZERO":   true,
+	"KEY_WIMAX":             true,
+	"KEY_BRIGHTNESS_TOGGLE": true,
+	"BTN_MISC":              true,
+	"BTN_MOUSE":             true,
+	"BTN_JOYSTICK":          true,
+	"BTN_GAMEPAD":           true,
+	"BTN_A":                 true,
+	"BTN_B":                 true,
+	"BTN_X":                 true,
+	"BTN_Y":                 true,
+	"BTN_DIGI":              true,
+	"BTN_WHEEL":             true,
+	"BTN_TRIGGER_HAPPY":     true,
+	"SW_RADIO":              true,
+	"SW_MAX":                true,
+	"REP_MAX":               true,
+	"FF_STATUS_MAX":         true,
+	"FF_EFFECT_MIN":         true,
+	"FF_EFFECT_MAX":         true,
+	"FF_WAVEFORM_MIN":       true,
+	"FF_WAVEFORM_MAX":       true,
+	"FF_MAX_EFFECTS":        true,
+}
+
+// Record name as the name of code in names, unless code already has a
+// better name. Of several names for one code, a name that is not in
+// alias_names wins, and otherwise the alphabetically first one, so that
+// the choice does not depend on map iteration order.
+func add_code_name(names map[int]string, code int, name string) {
+	if other, ok := names[code]; !ok || better_name(name, other) {
+		names[code] = name
+	}
+}
+
+func better_name(name, other string) bool {
+	if alias_names[name] != alias_names[other] {
+		return !alias_names[name]
+	}
+	return name < other
+}
+
+func unknown_name(code int) string {
+	return fmt.Sprintf("UNKNOWN(0x%x)", code)
+}
+
+// Return the highest code defined for an event type (e.g. REL_MAX for
+// EV_REL), or -1 if the type has no codes.
+func MaxCode(evtype int) int {
+	switch evtype {
+	case EV_SYN:
+		return SYN_MAX
+	case EV_KEY:
+		return KEY_MAX
+	case EV_REL:
+		return REL_MAX
+	case EV_ABS:
+		return ABS_MAX
+	case EV_MSC:
+		return MSC_MAX
+	case EV_SW:
+		return SW_MAX
+	case EV_LED:
+		return LED_MAX
+	case EV_SND:
+		return SND_MAX
+	case EV_REP:
+		return REP_MAX
+	case EV_FF:
+		return FF_MAX
+	case EV_FF_STATUS:
+		return FF_STATUS_MAX
+	}
+	return -1
+}
+
+// Returned by reads once the device is gone: it was unplugged, lost power
+// across a suspend/resume cycle, or its fd was revoked (systemd-logind
+// revokes device fds when the session becomes inactive). The device must
+// be opened again to continue receiving events.
+var ErrDeviceHangup = errors.New("evdev: device hung up")
+
+// Translate errors of a failed read.
+func read_error(err error) error {
+	if err == syscall.ENODEV {
+		return ErrDeviceHangup
+	}
+	return err
+}
+
+// Read and decode up to count events with a single read from r. Exactly
+// the events read are returned, whatever their timestamps. On error no
+// events are returned. A read of 0 bytes means the other end is gone
+// (it would otherwise look like an endless series of empty batches) and
+// is reported as io.EOF.
+func read_events(r io.Reader, count int) ([]InputEvent, error) {
+	events := get_events(count)
+	raw := get_raw(eventsize * count)
+	defer raw_pool.Put(raw)
+
+	n, err := read_raw(r, *raw)
+	if err != nil {
+		ReleaseEvents(events)
+		return nil, err
+	}
+
+	events = events[:n]
+	for i := range events {
+		decode_event((*raw)[i*eventsize:], &events[i])
+	}
+	return events, nil
+}
+
+// Read whole events into buffer with a single read from r, and return the
+// number of events read. The kernel never returns part of an event, but
+// other sources such as pipes may; the rest of a partial event is then
+// read before returning.
+func read_raw(r io.Reader, buffer []byte) (int, error) {
+	n, err := r.Read(buffer)
+	if err != nil {
+		return 0, read_error(err)
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	if rest := n % eventsize; rest != 0 {
+		if _, err := io.ReadFull(r, buffer[n:n+eventsize-rest]); err != nil {
+			return 0, read_error(err)
+		}
+		n += eventsize - rest
+	}
+	return n / eventsize, nil
+}
+
+// Return the index of the first SYN_REPORT in events, or -1.
+func frame_end(events []InputEvent) int {
+	for i := range events {
+		if events[i].Type == EV_SYN && events[i].Code == SYN_REPORT {
+			return i
+		}
+	}
+	return -1
+}
+
+// Event slices released with ReleaseEvents, and read buffers, for reuse
+// by read_events.
+var event_pool, raw_pool sync.Pool
+
+func get_events(count int) []InputEvent {
+	if p, ok := event_pool.Get().(*[]InputEvent); ok && cap(*p) >= count {
+		return (*p)[:count]
+	}
+	return make([]InputEvent, count)
+}
+
+func get_raw(size int) *[]byte {
+	if p, ok := raw_pool.Get().(*[]byte); ok && cap(*p) >= size {
+		*p = (*p)[:size]
+		return p
+	}
+	b := make([]byte, size)
+	return &b
+}
+
+// Hand a slice of events returned by Read (or TryRead) back for reuse by
+// later reads, which saves an allocation per read in long-running
+// programs. Releasing is optional; unreleased slices are garbage collected
+// as usual. The events must not be used after they are released, and the
+// slice must not be one that the package keeps using, such as the result
+// of ReadShared.
+func ReleaseEvents(events []InputEvent) {
+	if cap(events) == 0 {
+		return
+	}
+	events = events[:0]
+	event_pool.Put(&events)
+}
+
+// Buffers that are reused from one read to the next, so that reading does
+// not allocate once they have grown to size.
+type event_buffer struct {
+	raw    []byte
+	events []InputEvent
+}
+
+// Read and decode up to count events with a single read from r, like
+// read_events. The returned slice is overwritten by the next read.
+func (b *event_buffer) read(r io.Reader, count int) ([]InputEvent, error) {
+	if len(b.events) < count {
+		b.events = make([]InputEvent, count)
+	}
+	n, err := b.read_into(r, b.events[:count])
+	if err != nil {
+		return nil, err
+	}
+	return b.events[:n], nil
+}
+
+// Read and decode up to len(events) events into events with a single read
+// from r, returning the number of events decoded.
+func (b *event_buffer) read_into(r io.Reader, events []InputEvent) (int, error) {
+	size := eventsize * len(events)
+	if len(b.raw) < size {
+		b.raw = make([]byte, size)
+	}
+
+	n, err := read_raw(r, b.raw[:size])
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		decode_event(b.raw[i*eventsize:], &events[i])
+	}
+	return n, nil
+}
+
+// Byte order of the kernel's event structures, which is that of the host.
+var native_endian binary.ByteOrder = host_byte_order()
+
+func host_byte_order() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// Decode an event in the kernel's layout: host byte order, with timestamp
+// fields that are 64 or 32 bits wide depending on the platform.
+func decode_event(b []byte, ev *InputEvent) {
+	var sec, usec int64
+	if eventsize == 24 {
+		sec = int64(native_endian.Uint64(b[0:]))
+		usec = int64(native_endian.Uint64(b[8:]))
+	} else {
+		sec = int64(int32(native_endian.Uint32(b[0:])))
+		usec = int64(int32(native_endian.Uint32(b[4:])))
+	}
+	b = b[eventsize-8:]
+
+	ev.Time = syscall.NsecToTimeval(sec*1e9 + usec*1e3)
+	ev.Type = native_endian.Uint16(b[0:])
+	ev.Code = native_endian.Uint16(b[2:])
+	ev.Value = int32(native_endian.Uint32(b[4:]))
+}
+
+// Encode an event in the kernel's layout; the inverse of decode_event.
+func encode_event(b []byte, ev *InputEvent) {
+	sec, nsec := ev.Time.Unix()
+	if eventsize == 24 {
+		native_endian.PutUint64(b[0:], uint64(sec))
+		native_endian.PutUint64(b[8:], uint64(nsec/1e3))
+	} else {
+		native_endian.PutUint32(b[0:], uint32(sec))
+		native_endian.PutUint32(b[4:], uint32(nsec/1e3))
+	}
+	b = b[eventsize-8:]
+
+	native_endian.PutUint16(b[0:], ev.Type)
+	native_endian.PutUint16(b[2:], ev.Code)
+	native_endian.PutUint32(b[4:], uint32(ev.Value))
+}
+
+// Write events to w with a single write.
+func write_events(w io.Writer, events []InputEvent) error {
+	buffer := make([]byte, eventsize*len(events))
+	for i := range events {
+		encode_event(buffer[i*eventsize:], &events[i])
+	}
+	_, err := w.Write(buffer)
+	return err
+}
+
+// Read and decode a single event from r.
+func read_one_event(r io.Reader) (*InputEvent, error) {
+	var event InputEvent
+	buffer := make([]byte, eventsize)
+
+	if _, err := read_raw(r, buffer); err != nil {
+		return nil, err
+	}
+
+	decode_event(buffer, &event)
+	return &event, nil
+}
 
 type KeyEventState uint8
 
@@ -37,6 +393,11 @@ type KeyEvent struct {
 	Scancode uint16
 	Keycode  uint16
 	State    KeyEventState
+
+	// Hardware scancode from the EV_MSC/MSC_SCAN event preceding the key
+	// event, if any (see KeyEventsWithScan).
+	MscScan    int32
+	HasMscScan bool
 }
 
 func (kev *KeyEvent) New(ev *InputEvent) {
@@ -60,6 +421,34 @@ func NewKeyEvent(ev *InputEvent) *KeyEvent {
 	return kev
 }
 
+// Decode the key events in events, pairing each with the value of the
+// MSC_SCAN event that precedes it in the same frame. Many keyboards report
+// the raw hardware scancode this way just before the EV_KEY event, which
+// is what scancode-based remapping needs. Key events without a preceding
+// MSC_SCAN have HasMscScan unset. The returned key events point into
+// events.
+func KeyEventsWithScan(events []InputEvent) []*KeyEvent {
+	kevs := make([]*KeyEvent, 0)
+	scan, has_scan := int32(0), false
+
+	for i := range events {
+		ev := &events[i]
+		switch {
+		case ev.Type == EV_MSC && ev.Code == MSC_SCAN:
+			scan, has_scan = ev.Value, true
+		case ev.Type == EV_KEY:
+			kev := NewKeyEvent(ev)
+			kev.MscScan, kev.HasMscScan = scan, has_scan
+			kevs = append(kevs, kev)
+			has_scan = false
+		case ev.Type == EV_SYN && ev.Code == SYN_REPORT:
+			has_scan = false
+		}
+	}
+
+	return kevs
+}
+
 func (ev *KeyEvent) String() string {
 	state := "unknown"
 
@@ -81,10 +470,12 @@ func (ev *KeyEvent) String() string {
 // e.g. moving the mouse 5 units to the left.
 type RelEvent struct {
 	Event *InputEvent
+	Axis  string // name of the axis, e.g. "REL_X"
 }
 
 func (rev *RelEvent) New(ev *InputEvent) {
 	rev.Event = ev
+	rev.Axis = CodeName(EV_REL, int(ev.Code))
 }
 
 func NewRelEvent(ev *InputEvent) *RelEvent {
@@ -95,15 +486,117 @@ func NewRelEvent(ev *InputEvent) *RelEvent {
 
 func (ev *RelEvent) String() string {
 	return fmt.Sprintf("relative axis event at %d.%d, %s",
-		ev.Event.Time.Sec, ev.Event.Time.Usec,
-		REL[int(ev.Event.Code)])
+		ev.Event.Time.Sec, ev.Event.Time.Usec, ev.Axis)
+}
+
+// AbsEvents are used to describe absolute axis value changes,
+// e.g. the position of a finger on a touchpad.
+type AbsEvent struct {
+	Event *InputEvent
+	Axis  string // name of the axis, e.g. "ABS_MT_POSITION_X"
+}
+
+func (aev *AbsEvent) New(ev *InputEvent) {
+	aev.Event = ev
+	aev.Axis = CodeName(EV_ABS, int(ev.Code))
+}
+
+func NewAbsEvent(ev *InputEvent) *AbsEvent {
+	aev := &AbsEvent{}
+	aev.New(ev)
+	return aev
+}
+
+func (ev *AbsEvent) String() string {
+	return fmt.Sprintf("absolute axis event at %d.%d, %s",
+		ev.Event.Time.Sec, ev.Event.Time.Usec, ev.Axis)
+}
+
+// SynEvents are used to separate events into frames (SYN_REPORT) and to
+// report lost events (SYN_DROPPED).
+type SynEvent struct {
+	Event *InputEvent
+	Name  string // e.g. "SYN_REPORT"
+}
+
+func (sev *SynEvent) New(ev *InputEvent) {
+	sev.Event = ev
+	sev.Name = CodeName(EV_SYN, int(ev.Code))
 }
 
-// TODO: Make this work
+func NewSynEvent(ev *InputEvent) *SynEvent {
+	sev := &SynEvent{}
+	sev.New(ev)
+	return sev
+}
+
+func (ev *SynEvent) String() string {
+	return fmt.Sprintf("synchronization event at %d.%d, %s",
+		ev.Event.Time.Sec, ev.Event.Time.Usec, ev.Name)
+}
+
+// SwitchEvents are used to describe the state of binary switches,
+// e.g. a laptop lid or a headphone jack.
+type SwitchEvent struct {
+	Event  *InputEvent
+	Switch string // name of the switch, e.g. "SW_LID"
+	On     bool
+}
+
+func (swev *SwitchEvent) New(ev *InputEvent) {
+	swev.Event = ev
+	swev.Switch = CodeName(EV_SW, int(ev.Code))
+	swev.On = ev.Value != 0
+}
+
+func NewSwitchEvent(ev *InputEvent) *SwitchEvent {
+	swev := &SwitchEvent{}
+	swev.New(ev)
+	return swev
+}
+
+func (ev *SwitchEvent) String() string {
+	state := "off"
+	if ev.On {
+		state = "on"
+	}
+	return fmt.Sprintf("switch event at %d.%d, %s (%s)",
+		ev.Event.Time.Sec, ev.Event.Time.Usec, ev.Switch, state)
+}
+
+// Return a typed view of the event according to its type: a *KeyEvent,
+// *RelEvent, *AbsEvent, *SynEvent or *SwitchEvent. Events of other types
+// are returned as they are. Example:
+//
+//	switch e := evdev.Categorize(&ev).(type) {
+//	case *evdev.KeyEvent:
+//		fmt.Println(e.Scancode, e.State)
+//	case *evdev.AbsEvent:
+//		fmt.Println(e.Axis, e.Event.Value)
+//	}
+func Categorize(ev *InputEvent) interface{} {
+	switch ev.Type {
+	case EV_KEY:
+		return NewKeyEvent(ev)
+	case EV_REL:
+		return NewRelEvent(ev)
+	case EV_ABS:
+		return NewAbsEvent(ev)
+	case EV_SYN:
+		return NewSynEvent(ev)
+	case EV_SW:
+		return NewSwitchEvent(ev)
+	}
+	return ev
+}
 
+// Constructors of the typed event views by event type; see Categorize.
 var EventFactory map[uint16]interface{} = make(map[uint16]interface{})
 
 func init() {
 	EventFactory[uint16(EV_KEY)] = NewKeyEvent
 	EventFactory[uint16(EV_REL)] = NewRelEvent
+	EventFactory[uint16(EV_ABS)] = NewAbsEvent
+	EventFactory[uint16(EV_SYN)] = NewSynEvent
+	EventFactory[uint16(EV_SW)] = NewSwitchEvent
 }
@@ -0,0 +1,26 @@
+//go:build linux && go1.23
+// +build linux,go1.23
+
+package evdev_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/johan-bolmsjo/golang-evdev"
+)
+
+// Reading events with a range loop. Events takes a context so that the
+// loop can be stopped from elsewhere; context.Background() reads until
+// the device fails.
+func ExampleInputDevice_Events() {
+	device, _ := evdev.Open("/dev/input/event3")
+
+	for ev, err := range device.Events(context.Background()) {
+		if err != nil {
+			fmt.Println(err)
+			break
+		}
+		fmt.Println(&ev)
+	}
+}
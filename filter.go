@@ -0,0 +1,99 @@
+package evdev
+
+// Decides which events an application wants to receive. Filters can be
+// combined with AllOf, AnyOf and Not, applied to a batch of events with
+// Select, and attached to a Broadcaster subscription or a merged stream
+// (see NewMergedStreamFiltered).
+type Filter interface {
+	Match(ev *InputEvent) bool
+}
+
+// Adapter to use an ordinary function as a Filter.
+type FilterFunc func(ev *InputEvent) bool
+
+func (f FilterFunc) Match(ev *InputEvent) bool {
+	return f(ev)
+}
+
+// Match events of any of the given types, e.g. ByType(EV_KEY).
+func ByType(types ...int) Filter {
+	set := int_set(types)
+	return FilterFunc(func(ev *InputEvent) bool {
+		return set[int(ev.Type)]
+	})
+}
+
+// Match events with any of the given codes, e.g. ByCode(KEY_A, KEY_B).
+// Codes are only unique within an event type, so this is usually combined
+// with ByType.
+func ByCode(codes ...int) Filter {
+	set := int_set(codes)
+	return FilterFunc(func(ev *InputEvent) bool {
+		return set[int(ev.Code)]
+	})
+}
+
+// Match key presses, but not releases or autorepeats.
+func KeyDownOnly() Filter {
+	return FilterFunc(func(ev *InputEvent) bool {
+		return ev.Type == EV_KEY && ev.Value == int32(KeyDown)
+	})
+}
+
+// Match events that all of the filters match.
+func AllOf(filters ...Filter) Filter {
+	return FilterFunc(func(ev *InputEvent) bool {
+		for _, f := range filters {
+			if !f.Match(ev) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Match events that any of the filters matches.
+func AnyOf(filters ...Filter) Filter {
+	return FilterFunc(func(ev *InputEvent) bool {
+		for _, f := range filters {
+			if f.Match(ev) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Match events that f does not match.
+func Not(f Filter) Filter {
+	return FilterFunc(func(ev *InputEvent) bool {
+		return !f.Match(ev)
+	})
+}
+
+// Return an EventTransformer that keeps the events matched by f, so that
+// filters can be used as a Pipeline stage.
+func Select(f Filter) EventTransformer {
+	return TransformFunc(func(events []InputEvent) []InputEvent {
+		return filter_events(f, events)
+	})
+}
+
+// Return the events matched by f, in a new slice.
+func filter_events(f Filter, events []InputEvent) []InputEvent {
+	out := make([]InputEvent, 0, len(events))
+	for i := range events {
+		if f.Match(&events[i]) {
+			out = append(out, events[i])
+		}
+	}
+	return out
+}
+
+func int_set(values []int) map[int]bool {
+	set := make(map[int]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
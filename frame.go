@@ -0,0 +1,100 @@
+package evdev
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// A complete group of events from one device, as delimited by SYN_REPORT,
+// tagged for forwarding to another process or machine.
+type Frame struct {
+	Seq    uint64       // sequence number assigned by the sender
+	Device string       // identifier of the source device
+	Events []InputEvent // events of the frame, normally ending in SYN_REPORT
+}
+
+// The binary frame encoding is, with all integers little endian:
+//
+//	version   uint8  (frame_version)
+//	seq       uint64
+//	devlen    uint16, followed by devlen bytes of device id
+//	count     uint16, followed by count events of:
+//	  sec     int64
+//	  usec    int32
+//	  type    uint16
+//	  code    uint16
+//	  value   int32
+const (
+	frame_version     = 1
+	frame_header_size = 1 + 8 + 2 + 2
+	frame_event_size  = 8 + 4 + 2 + 2 + 4
+)
+
+var err_short_frame = errors.New("evdev: short frame")
+
+// Encode the frame in its compact binary form.
+func (f *Frame) MarshalBinary() ([]byte, error) {
+	if len(f.Device) > 0xffff || len(f.Events) > 0xffff {
+		return nil, errors.New("evdev: frame too large")
+	}
+
+	b := make([]byte, frame_header_size+len(f.Device)+len(f.Events)*frame_event_size)
+	b[0] = frame_version
+	binary.LittleEndian.PutUint64(b[1:], f.Seq)
+	binary.LittleEndian.PutUint16(b[9:], uint16(len(f.Device)))
+	n := 11 + copy(b[11:], f.Device)
+	binary.LittleEndian.PutUint16(b[n:], uint16(len(f.Events)))
+	n += 2
+
+	for _, ev := range f.Events {
+		e := b[n : n+frame_event_size]
+		binary.LittleEndian.PutUint64(e, uint64(ev.Time.Sec))
+		binary.LittleEndian.PutUint32(e[8:], uint32(ev.Time.Usec))
+		binary.LittleEndian.PutUint16(e[12:], ev.Type)
+		binary.LittleEndian.PutUint16(e[14:], ev.Code)
+		binary.LittleEndian.PutUint32(e[16:], uint32(ev.Value))
+		n += frame_event_size
+	}
+
+	return b, nil
+}
+
+// Decode a frame produced by MarshalBinary.
+func (f *Frame) UnmarshalBinary(b []byte) error {
+	if len(b) < frame_header_size {
+		return err_short_frame
+	}
+	if b[0] != frame_version {
+		return fmt.Errorf("evdev: unknown frame version %d", b[0])
+	}
+
+	seq := binary.LittleEndian.Uint64(b[1:])
+	devlen := int(binary.LittleEndian.Uint16(b[9:]))
+	b = b[11:]
+	if len(b) < devlen+2 {
+		return err_short_frame
+	}
+	device := string(b[:devlen])
+	count := int(binary.LittleEndian.Uint16(b[devlen:]))
+	b = b[devlen+2:]
+	if len(b) != count*frame_event_size {
+		return err_short_frame
+	}
+
+	events := make([]InputEvent, count)
+	for i := range events {
+		e := b[i*frame_event_size:]
+		usec := int64(int32(binary.LittleEndian.Uint32(e[8:])))
+		events[i] = InputEvent{
+			Time:  syscall.NsecToTimeval(int64(binary.LittleEndian.Uint64(e))*1e9 + usec*1e3),
+			Type:  binary.LittleEndian.Uint16(e[12:]),
+			Code:  binary.LittleEndian.Uint16(e[14:]),
+			Value: int32(binary.LittleEndian.Uint32(e[16:])),
+		}
+	}
+
+	f.Seq, f.Device, f.Events = seq, device, events
+	return nil
+}
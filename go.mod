@@ -1,3 +1,5 @@
 module github.com/johan-bolmsjo/golang-evdev
 
+go 1.23
+
 require github.com/npat-efault/poller v2.0.0+incompatible
@@ -0,0 +1,97 @@
+package evdev
+
+// HID usage pages covered by KeyToHIDUsage and HIDUsageToKey.
+const (
+	HIDPageKeyboard = 0x07 // Keyboard/Keypad page
+	HIDPageConsumer = 0x0c // Consumer page
+)
+
+// Keyboard page usages (index) to key codes, as in the kernel's
+// hid_keyboard[] table in drivers/hid/hid-input.c. Zero means unmapped.
+var hid_keyboard = [256]uint8{
+	0, 0, 0, 0, 30, 48, 46, 32, 18, 33, 34, 35, 23, 36, 37, 38,
+	50, 49, 24, 25, 16, 19, 31, 20, 22, 47, 17, 45, 21, 44, 2, 3,
+	4, 5, 6, 7, 8, 9, 10, 11, 28, 1, 14, 15, 57, 12, 13, 26,
+	27, 43, 43, 39, 40, 41, 51, 52, 53, 58, 59, 60, 61, 62, 63, 64,
+	65, 66, 67, 68, 87, 88, 99, 70, 119, 110, 102, 104, 111, 107, 109, 106,
+	105, 108, 103, 69, 98, 55, 74, 78, 96, 79, 80, 81, 75, 76, 77, 71,
+	72, 73, 82, 83, 86, 127, 116, 117, 183, 184, 185, 186, 187, 188, 189, 190,
+	191, 192, 193, 194, 134, 138, 130, 132, 128, 129, 131, 137, 133, 135, 136, 113,
+	115, 114, 0, 0, 0, 121, 0, 89, 93, 124, 92, 94, 95, 0, 0, 0,
+	122, 123, 90, 91, 85, 0, 0, 0, 0, 0, 0, 0, 111, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 179, 180, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 111, 0, 0, 0, 0, 0, 0, 0,
+	29, 42, 56, 125, 97, 54, 100, 126, 164, 166, 165, 163, 161, 115, 114, 113,
+	150, 158, 159, 128, 136, 177, 178, 176, 142, 152, 173, 140, 0, 0, 0, 0,
+}
+
+// Common consumer page usages to key codes (from hidinput_configure_usage).
+var hid_consumer = map[uint16]int{
+	0x030: KEY_POWER,
+	0x032: KEY_SLEEP,
+	0x06f: KEY_BRIGHTNESSUP,
+	0x070: KEY_BRIGHTNESSDOWN,
+	0x0b0: KEY_PLAY,
+	0x0b1: KEY_PAUSE,
+	0x0b2: KEY_RECORD,
+	0x0b3: KEY_FASTFORWARD,
+	0x0b4: KEY_REWIND,
+	0x0b5: KEY_NEXTSONG,
+	0x0b6: KEY_PREVIOUSSONG,
+	0x0b7: KEY_STOPCD,
+	0x0b8: KEY_EJECTCD,
+	0x0cd: KEY_PLAYPAUSE,
+	0x0e2: KEY_MUTE,
+	0x0e9: KEY_VOLUMEUP,
+	0x0ea: KEY_VOLUMEDOWN,
+	0x183: KEY_CONFIG,
+	0x18a: KEY_MAIL,
+	0x192: KEY_CALC,
+	0x194: KEY_FILE,
+	0x221: KEY_SEARCH,
+	0x223: KEY_HOMEPAGE,
+	0x224: KEY_BACK,
+	0x225: KEY_FORWARD,
+	0x226: KEY_STOP,
+	0x227: KEY_REFRESH,
+	0x22a: KEY_BOOKMARKS,
+}
+
+// Key codes to (page, usage), preferring the consumer page for keys that
+// appear on both pages (media keys) and the lowest usage otherwise.
+var key_to_hid = make(map[int][2]uint16)
+
+func init() {
+	for usage, code := range hid_keyboard {
+		if _, ok := key_to_hid[int(code)]; code != 0 && !ok {
+			key_to_hid[int(code)] = [2]uint16{HIDPageKeyboard, uint16(usage)}
+		}
+	}
+	for usage, code := range hid_consumer {
+		key_to_hid[code] = [2]uint16{HIDPageConsumer, usage}
+	}
+}
+
+// Map a key code to the HID usage page and usage that produce it, covering
+// the keyboard page and the common consumer page (media) keys.
+func KeyToHIDUsage(code int) (page, usage uint16, ok bool) {
+	hid, ok := key_to_hid[code]
+	return hid[0], hid[1], ok
+}
+
+// Map a HID usage on the keyboard or consumer page to the key code the
+// kernel's HID driver reports for it.
+func HIDUsageToKey(page, usage uint16) (int, bool) {
+	switch page {
+	case HIDPageKeyboard:
+		if usage < uint16(len(hid_keyboard)) && hid_keyboard[usage] != 0 {
+			return int(hid_keyboard[usage]), true
+		}
+	case HIDPageConsumer:
+		code, ok := hid_consumer[usage]
+		return code, ok
+	}
+	return 0, false
+}
@@ -0,0 +1,39 @@
+//go:build linux && go1.23
+// +build linux,go1.23
+
+package evdev
+
+import (
+	"context"
+	"iter"
+)
+
+// Return an iterator over the events read from the device:
+//
+//	for ev, err := range dev.Events(ctx) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// Iteration stops when ctx is done or a read fails with an error that the
+// device's ReadErrorPolicy does not recover from, in which case the
+// terminal error (ctx.Err() on cancellation) is yielded exactly once with
+// a zero event.
+func (dev *InputDevice) Events(ctx context.Context) iter.Seq2[InputEvent, error] {
+	return func(yield func(InputEvent, error) bool) {
+		for {
+			events, err := dev.stream_read(ctx)
+			if err != nil {
+				yield(InputEvent{}, err)
+				return
+			}
+			for _, ev := range events {
+				if !yield(ev, nil) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// +build linux
+
+package evdev
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// JSON form of an input event, as written by EncodeEvents.
+type json_event struct {
+	Sec      int64  `json:"sec"`
+	Usec     int64  `json:"usec"`
+	Type     uint16 `json:"type"`
+	TypeName string `json:"type_name"`
+	Code     uint16 `json:"code"`
+	CodeName string `json:"code_name"`
+	Value    int32  `json:"value"`
+}
+
+// Read events from the device and write each of them to w as a line of
+// JSON (ndjson) until ctx is done or reading or writing fails. A line
+// looks like:
+//
+//	{"sec":1,"usec":500,"type":1,"type_name":"EV_KEY","code":30,"code_name":"KEY_A","value":1}
+//
+// The error is ctx.Err() when the stream was stopped through ctx.
+func (dev *InputDevice) EncodeEvents(ctx context.Context, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for {
+		events, err := dev.stream_read(ctx)
+		if err != nil {
+			return err
+		}
+		if err := encode_json_events(enc, events); err != nil {
+			return err
+		}
+	}
+}
+
+func encode_json_events(enc *json.Encoder, events []InputEvent) error {
+	for _, ev := range events {
+		err := enc.Encode(json_event{
+			Sec:      int64(ev.Time.Sec),
+			Usec:     int64(ev.Time.Usec),
+			Type:     ev.Type,
+			TypeName: TypeName(int(ev.Type)),
+			Code:     ev.Code,
+			CodeName: CodeName(int(ev.Type), int(ev.Code)),
+			Value:    ev.Value,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package evdev
+
+// Rewrites the codes of EV_KEY events: each key code in the map is
+// replaced by the code it maps to, e.g. {KEY_CAPSLOCK: KEY_ESC,
+// KEY_ESC: KEY_CAPSLOCK} swaps caps lock and escape. Keys that are not in
+// the map pass through unchanged. A Keymap is an EventTransformer and
+// modifies the events in place.
+type Keymap map[int]int
+
+func (m Keymap) Transform(events []InputEvent) []InputEvent {
+	for i := range events {
+		m.remap(&events[i])
+	}
+	return events
+}
+
+func (m Keymap) remap(ev *InputEvent) {
+	if ev.Type != EV_KEY {
+		return
+	}
+	if code, ok := m[int(ev.Code)]; ok {
+		ev.Code = uint16(code)
+	}
+}
@@ -0,0 +1,145 @@
+package evdev
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Short names of the modifier keys accepted by ParseKeySequence.
+var modifier_names = map[string]int{
+	"ctrl":  KEY_LEFTCTRL,
+	"shift": KEY_LEFTSHIFT,
+	"alt":   KEY_LEFTALT,
+	"altgr": KEY_RIGHTALT,
+	"meta":  KEY_LEFTMETA,
+	"super": KEY_LEFTMETA,
+}
+
+// A character on a US keyboard layout: the key that types it and whether
+// shift must be held.
+type us_char struct {
+	code  int
+	shift bool
+}
+
+// Characters other than letters accepted by ParseKeySequence, and the
+// keys that type them.
+var us_chars = map[rune]us_char{
+	'1': {KEY_1, false}, '!': {KEY_1, true},
+	'2': {KEY_2, false}, '@': {KEY_2, true},
+	'3': {KEY_3, false}, '#': {KEY_3, true},
+	'4': {KEY_4, false}, '$': {KEY_4, true},
+	'5': {KEY_5, false}, '%': {KEY_5, true},
+	'6': {KEY_6, false}, '^': {KEY_6, true},
+	'7': {KEY_7, false}, '&': {KEY_7, true},
+	'8': {KEY_8, false}, '*': {KEY_8, true},
+	'9': {KEY_9, false}, '(': {KEY_9, true},
+	'0': {KEY_0, false}, ')': {KEY_0, true},
+	'-': {KEY_MINUS, false}, '_': {KEY_MINUS, true},
+	'=': {KEY_EQUAL, false},
+	'[': {KEY_LEFTBRACE, false}, '{': {KEY_LEFTBRACE, true},
+	']': {KEY_RIGHTBRACE, false}, '}': {KEY_RIGHTBRACE, true},
+	'\\': {KEY_BACKSLASH, false}, '|': {KEY_BACKSLASH, true},
+	';': {KEY_SEMICOLON, false}, ':': {KEY_SEMICOLON, true},
+	'\'': {KEY_APOSTROPHE, false}, '"': {KEY_APOSTROPHE, true},
+	'`': {KEY_GRAVE, false}, '~': {KEY_GRAVE, true},
+	',': {KEY_COMMA, false}, '<': {KEY_COMMA, true},
+	'.': {KEY_DOT, false}, '>': {KEY_DOT, true},
+	'/': {KEY_SLASH, false}, '?': {KEY_SLASH, true},
+}
+
+// Turn a key sequence spec into the key press and release events that
+// type it, each event followed by a SYN_REPORT as a keyboard reports it.
+// The spec is a whitespace separated list of chords, and a chord is one
+// or more keys joined by "+":
+//
+//	ctrl+alt+t   press ctrl, alt and t, then release them in reverse order
+//	h e l l o    tap h, e, l, l and o one after the other
+//
+// Keys are the short modifier names (ctrl, shift, alt, altgr, meta,
+// super), key names with or without the KEY_ prefix, in any case (enter,
+// KEY_ENTER, 1), or single characters as typed on a US keyboard layout.
+// Characters that need shift, such as "H" or "!", add shift to the chord.
+// "+" separates keys, so it must be given as shift+equal. The modifiers
+// of a chord are pressed before its other keys. The event timestamps are
+// left zero.
+func ParseKeySequence(spec string) ([]InputEvent, error) {
+	events := make([]InputEvent, 0)
+	for _, chord := range strings.Fields(spec) {
+		codes, err := parse_chord(chord)
+		if err != nil {
+			return nil, err
+		}
+		for _, code := range codes {
+			events = append(events, key_event(code, KeyDown)...)
+		}
+		for i := len(codes) - 1; i >= 0; i-- {
+			events = append(events, key_event(codes[i], KeyUp)...)
+		}
+	}
+	return events, nil
+}
+
+// Return the key codes of a chord, modifiers first.
+func parse_chord(chord string) ([]int, error) {
+	modifiers := make([]int, 0)
+	codes := make([]int, 0)
+	shifted := false
+	for _, name := range strings.Split(chord, "+") {
+		if code, ok := modifier_names[strings.ToLower(name)]; ok {
+			modifiers = append(modifiers, code)
+			shifted = shifted || code == KEY_LEFTSHIFT
+			continue
+		}
+		if c, ok := char_key(name); ok {
+			if c.shift && !shifted {
+				modifiers = append(modifiers, KEY_LEFTSHIFT)
+				shifted = true
+			}
+			codes = append(codes, c.code)
+			continue
+		}
+		code, err := key_code(name)
+		if err != nil {
+			return nil, fmt.Errorf("chord %q: %s", chord, err)
+		}
+		codes = append(codes, code)
+	}
+	return append(modifiers, codes...), nil
+}
+
+// Look up a single character on the US keyboard layout.
+func char_key(name string) (us_char, bool) {
+	r, size := utf8.DecodeRuneInString(name)
+	if size == 0 || size != len(name) {
+		return us_char{}, false
+	}
+	if r >= 'A' && r <= 'Z' {
+		return us_char{ecodes["KEY_"+name], true}, true
+	}
+	if r >= 'a' && r <= 'z' {
+		return us_char{ecodes["KEY_"+strings.ToUpper(name)], false}, true
+	}
+	c, ok := us_chars[r]
+	return c, ok
+}
+
+// Look up a key by name, with or without the KEY_ prefix.
+func key_code(name string) (int, error) {
+	upper := strings.ToUpper(name)
+	if !strings.HasPrefix(upper, "KEY_") {
+		upper = "KEY_" + upper
+	}
+	if code, ok := ecodes[upper]; ok && upper != "KEY_MAX" {
+		return code, nil
+	}
+	return 0, fmt.Errorf("unknown key %q", name)
+}
+
+func key_event(code int, state KeyEventState) []InputEvent {
+	return []InputEvent{
+		{Type: EV_KEY, Code: uint16(code), Value: int32(state)},
+		{Type: EV_SYN, Code: SYN_REPORT},
+	}
+}
@@ -0,0 +1,114 @@
+// +build linux
+
+package evdev
+
+import (
+	"context"
+	"sync"
+)
+
+// An event together with the device it was read from.
+type DeviceEvent struct {
+	Device *InputDevice
+	Event  InputEvent
+	Err    error // set, with a zero Event, when reading from Device failed
+}
+
+// Read the given devices concurrently (each with its ReadErrorPolicy) and
+// merge their events into one channel, e.g. to watch all keyboards for a
+// hotkey. When reading a device fails, for instance because it was closed
+// or unplugged, a DeviceEvent carrying the error is sent and the device is
+// not read from again. The stream ends, and the channel is closed, when
+// ctx is done or once reading has failed for all devices. The devices are
+// not closed. Up to 64 batches of events are buffered; beyond that,
+// reading stops until the consumer catches up (see NewMergedStream for
+// other choices).
+func MergeStreams(ctx context.Context, devs ...*InputDevice) <-chan DeviceEvent {
+	return NewMergedStream(ctx, 64, OverflowBlock, devs...).Events
+}
+
+// The merged events of several devices; see MergeStreams.
+type MergedStream struct {
+	Events <-chan DeviceEvent
+
+	queue *event_queue
+}
+
+// Like MergeStreams, but buffer up to buffer batches of events and apply
+// policy when the buffer is full.
+func NewMergedStream(ctx context.Context, buffer int, policy OverflowPolicy, devs ...*InputDevice) *MergedStream {
+	return NewMergedStreamFiltered(ctx, buffer, policy, nil, devs...)
+}
+
+// Like NewMergedStream, but only deliver the events that filter matches.
+// Filtering happens before buffering, so events that are not wanted take
+// no room in the buffer. Read errors are always delivered.
+func NewMergedStreamFiltered(ctx context.Context, buffer int, policy OverflowPolicy, filter Filter, devs ...*InputDevice) *MergedStream {
+	ch := make(chan DeviceEvent)
+	s := &MergedStream{Events: ch, queue: new_event_queue(buffer, policy)}
+
+	var wg sync.WaitGroup
+	for _, dev := range devs {
+		wg.Add(1)
+		go func(dev *InputDevice) {
+			defer wg.Done()
+			for {
+				events, err := dev.stream_read(ctx)
+				if err != nil {
+					if ctx.Err() == nil {
+						s.queue.put(queued_batch{dev: dev, err: err})
+					}
+					return
+				}
+				if filter == nil {
+					s.queue.put(queued_batch{dev: dev, events: append([]InputEvent(nil), events...)})
+				} else if batch := filter_events(filter, events); len(batch) > 0 {
+					s.queue.put(queued_batch{dev: dev, events: batch})
+				}
+			}
+		}(dev)
+	}
+	go func() {
+		wg.Wait()
+		s.queue.close()
+	}()
+	go s.deliver(ctx, ch)
+
+	return s
+}
+
+func (s *MergedStream) deliver(ctx context.Context, ch chan<- DeviceEvent) {
+	defer close(ch)
+	defer s.queue.cancel() // unblocks the readers if they wait for room
+
+	send := func(de DeviceEvent) bool {
+		select {
+		case ch <- de:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for {
+		batch, ok := s.queue.get()
+		if !ok {
+			return
+		}
+		if batch.err != nil {
+			if !send(DeviceEvent{Device: batch.dev, Err: batch.err}) {
+				return
+			}
+			continue
+		}
+		for _, ev := range batch.events {
+			if !send(DeviceEvent{Device: batch.dev, Event: ev}) {
+				return
+			}
+		}
+	}
+}
+
+// Return the number of events dropped by the stream's OverflowPolicy.
+func (s *MergedStream) Dropped() uint64 {
+	return s.queue.dropped_events()
+}
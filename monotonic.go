@@ -0,0 +1,73 @@
+// +build linux
+
+package evdev
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Clocks that the kernel can timestamp events with; see SetClockID.
+const (
+	CLOCK_REALTIME  = 0 // wall clock time, the default
+	CLOCK_MONOTONIC = 1 // time since an arbitrary point, not affected by setting the wall clock
+	CLOCK_BOOTTIME  = 7 // like CLOCK_MONOTONIC, but including time spent suspended
+)
+
+// Return the current time of CLOCK_MONOTONIC.
+func monotonic_now() time.Duration {
+	var ts syscall.Timespec
+	syscall.Syscall(syscall.SYS_CLOCK_GETTIME, CLOCK_MONOTONIC, uintptr(unsafe.Pointer(&ts)), 0)
+	return time.Duration(ts.Nano())
+}
+
+// CLOCK_MONOTONIC when the package was initialized; the zero point of
+// RelativeTime.
+var process_start = monotonic_now()
+
+// Have the kernel timestamp the device's events with the given clock, one
+// of CLOCK_REALTIME, CLOCK_MONOTONIC or CLOCK_BOOTTIME (EVIOCSCLOCKID).
+// The setting applies to this file descriptor only, and events that are
+// already queued keep their timestamps.
+func (dev *InputDevice) SetClockID(clockid int) error {
+	if err := dev.lock(); err != nil {
+		return err
+	}
+	defer dev.File.Unlock()
+	sysfd := uintptr(dev.File.Sysfd())
+
+	id := int32(clockid)
+	if errno := ioctl(sysfd, uintptr(EVIOCSCLOCKID), unsafe.Pointer(&id)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Have the kernel timestamp the device's events with CLOCK_MONOTONIC
+// instead of the default CLOCK_REALTIME. Monotonic timestamps do not jump
+// when the wall clock is set, and are what RelativeTime and MonotonicTime
+// expect.
+func (dev *InputDevice) SetMonotonicClock() error {
+	return dev.SetClockID(CLOCK_MONOTONIC)
+}
+
+// Return the time of the event relative to the start of the process, on
+// the CLOCK_MONOTONIC basis. This is only meaningful for a device whose
+// timestamps are on CLOCK_MONOTONIC (see SetMonotonicClock); with the
+// default CLOCK_REALTIME timestamps the result is garbage. Differences
+// between relative times are safe to use for latency and interval math,
+// and events read before the process started come out negative.
+func (ev *InputEvent) RelativeTime() time.Duration {
+	return ev.SinceBoot() - process_start
+}
+
+// Return the time of the event as a time.Time that carries a reading of
+// Go's monotonic clock, so that time.Since and Time.Sub measure the
+// latency of the event without being affected by wall clock changes. Like
+// RelativeTime, this is only meaningful for CLOCK_MONOTONIC timestamps.
+func (ev *InputEvent) MonotonicTime() time.Time {
+	now := time.Now()
+	age := monotonic_now() - ev.SinceBoot()
+	return now.Add(-age)
+}
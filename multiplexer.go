@@ -0,0 +1,185 @@
+// +build linux
+
+package evdev
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+)
+
+// Returned by Multiplexer methods once the multiplexer is closed.
+var ErrMultiplexerClosed = errors.New("evdev: multiplexer closed")
+
+// Waits for events on many devices at once, using a single epoll
+// instance, so that one goroutine can serve any number of devices:
+//
+//	m, err := evdev.NewMultiplexer(devices...)
+//	for {
+//		dev, events, err := m.Wait()
+//		...
+//	}
+//
+// Wait must not be called concurrently with itself; the other methods may
+// be called from any goroutine.
+type Multiplexer struct {
+	mu      sync.Mutex
+	epfd    int
+	wake    [2]int                 // pipe that interrupts a Wait on Close
+	devices map[int32]*InputDevice // by file descriptor
+	ready   []*InputDevice         // reported readable, not yet read
+	waiting bool                   // Wait is blocked in epoll_wait
+	closed  bool
+	events  [16]syscall.EpollEvent // used by Wait only
+}
+
+// Create a multiplexer that waits for events on the given devices. More
+// devices can be added with Add.
+func NewMultiplexer(devices ...*InputDevice) (*Multiplexer, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	m := &Multiplexer{epfd: epfd, devices: make(map[int32]*InputDevice)}
+	if err := syscall.Pipe2(m.wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(m.wake[0])}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, m.wake[0], &ev); err != nil {
+		m.release()
+		return nil, err
+	}
+
+	for _, dev := range devices {
+		if err := m.Add(dev); err != nil {
+			m.release()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Start waiting for events on dev.
+func (m *Multiplexer) Add(dev *InputDevice) error {
+	if dev.File == nil {
+		return ErrNotOpen
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrMultiplexerClosed
+	}
+
+	fd := dev.File.Sysfd()
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+	if err := syscall.EpollCtl(m.epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		return err
+	}
+	m.devices[int32(fd)] = dev
+	return nil
+}
+
+// Stop waiting for events on dev. Remove a device before closing it, and
+// remove devices that Wait reported an error for (such as
+// ErrDeviceHangup), as they would otherwise be reported again.
+func (m *Multiplexer) Remove(dev *InputDevice) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrMultiplexerClosed
+	}
+
+	for fd, d := range m.devices {
+		if d == dev {
+			delete(m.devices, fd)
+			m.remove_ready(dev)
+			return syscall.EpollCtl(m.epfd, syscall.EPOLL_CTL_DEL, int(fd), nil)
+		}
+	}
+	return nil
+}
+
+func (m *Multiplexer) remove_ready(dev *InputDevice) {
+	ready := m.ready[:0]
+	for _, d := range m.ready {
+		if d != dev {
+			ready = append(ready, d)
+		}
+	}
+	m.ready = ready
+}
+
+// Block until one of the devices has events, and return the device and
+// its events (as from InputDevice.TryRead). If reading from the device
+// fails, the device is returned along with the error. Ready devices are
+// served in turn, so a busy device does not starve the others. Close
+// makes a blocked Wait return ErrMultiplexerClosed.
+func (m *Multiplexer) Wait() (*InputDevice, []InputEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		if m.closed {
+			return nil, nil, ErrMultiplexerClosed
+		}
+		for len(m.ready) > 0 {
+			dev := m.ready[0]
+			m.ready = m.ready[1:]
+			events, err := dev.TryRead()
+			if err == ErrNoEvents {
+				continue
+			}
+			return dev, events, err
+		}
+
+		m.waiting = true
+		m.mu.Unlock()
+		n, err := syscall.EpollWait(m.epfd, m.events[:], -1)
+		m.mu.Lock()
+		m.waiting = false
+
+		if m.closed {
+			m.release()
+			return nil, nil, ErrMultiplexerClosed
+		}
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		for _, ev := range m.events[:n] {
+			if dev, ok := m.devices[ev.Fd]; ok {
+				m.ready = append(m.ready, dev)
+			}
+		}
+	}
+}
+
+// Stop waiting and release the multiplexer's resources. The devices are
+// not closed.
+func (m *Multiplexer) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	m.devices = nil
+	m.ready = nil
+
+	if m.waiting {
+		// Wake up Wait, which releases the resources once it is done
+		// with them.
+		syscall.Write(m.wake[1], []byte{0})
+		return nil
+	}
+	m.release()
+	return nil
+}
+
+func (m *Multiplexer) release() {
+	syscall.Close(m.epfd)
+	syscall.Close(m.wake[0])
+	syscall.Close(m.wake[1])
+}
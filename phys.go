@@ -0,0 +1,60 @@
+package evdev
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Structured form of an input device's physical topology string (the
+// Phys field). Fields that could not be determined are left empty, and
+// Interface is -1 when the topology carries no interface index.
+type PhysInfo struct {
+	Raw        string // the unparsed topology string
+	Bus        string // bus kind, e.g. "usb", "bluetooth", "isa"
+	Controller string // host controller address, e.g. "0000:00:12.0"
+	Port       string // port path on the controller, e.g. "2" or "1.4.3"
+	Interface  int    // interface index from the trailing "/inputN"
+}
+
+var bluetooth_addr = regexp.MustCompile(`^[0-9a-fA-F]{2}(:[0-9a-fA-F]{2}){5}$`)
+
+// Parse a physical topology string into a PhysInfo. Examples:
+//
+//	usb-0000:00:12.0-2/input0  -> usb, 0000:00:12.0, port 2, interface 0
+//	isa0060/serio0/input0      -> isa, isa0060, port serio0, interface 0
+//	00:1a:7d:da:71:13          -> bluetooth, 00:1a:7d:da:71:13
+//
+// The parse is best-effort; unknown formats only populate Raw (and
+// Interface, if present).
+func ParsePhys(phys string) PhysInfo {
+	info := PhysInfo{Raw: phys, Interface: -1}
+
+	head := phys
+	if i := strings.LastIndex(phys, "/input"); i >= 0 {
+		if n, err := strconv.Atoi(phys[i+len("/input"):]); err == nil {
+			info.Interface = n
+			head = phys[:i]
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(head, "usb-"):
+		info.Bus = "usb"
+		rest := head[len("usb-"):]
+		if i := strings.LastIndex(rest, "-"); i >= 0 {
+			info.Controller, info.Port = rest[:i], rest[i+1:]
+		} else {
+			info.Controller = rest
+		}
+	case bluetooth_addr.MatchString(head):
+		info.Bus = "bluetooth"
+		info.Controller = head
+	case strings.Contains(head, "/"):
+		parts := strings.SplitN(head, "/", 2)
+		info.Bus = strings.TrimRight(parts[0], "0123456789")
+		info.Controller, info.Port = parts[0], parts[1]
+	}
+
+	return info
+}
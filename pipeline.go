@@ -0,0 +1,119 @@
+package evdev
+
+import "io"
+
+// A stage of event processing, such as a filter, remapper or smoother. It
+// receives a batch of events (e.g. the result of one Read) and returns the
+// events to pass on to the next stage. Transformers may keep state between
+// calls and may modify the events they are given.
+type EventTransformer interface {
+	Transform(events []InputEvent) []InputEvent
+}
+
+// Adapter to use an ordinary function as an EventTransformer.
+type TransformFunc func(events []InputEvent) []InputEvent
+
+func (f TransformFunc) Transform(events []InputEvent) []InputEvent {
+	return f(events)
+}
+
+// A chain of transformers applied in order. A Pipeline is an
+// EventTransformer itself, so pipelines can be nested:
+//
+//	p := evdev.NewPipeline(absfilter, keymap)
+//	events, err := dev.Read()
+//	events = p.Transform(events)
+//
+// Run connects a pipeline between a source of events and a sink.
+type Pipeline []EventTransformer
+
+// Create a pipeline of the given stages.
+func NewPipeline(stages ...EventTransformer) Pipeline {
+	return Pipeline(stages)
+}
+
+// Pass events through all stages in order.
+func (p Pipeline) Transform(events []InputEvent) []InputEvent {
+	for _, stage := range p {
+		if len(events) == 0 {
+			break
+		}
+		events = stage.Transform(events)
+	}
+	return events
+}
+
+// A stage that works on one event at a time, such as a debouncer or a
+// logger. Process returns the events to pass on in place of ev: none to
+// drop it, ev itself to pass it through, or several to expand it. Use
+// EachEvent to add a Stage to a Pipeline.
+type Stage interface {
+	Process(ev InputEvent) []InputEvent
+}
+
+// Adapter to use an ordinary function as a Stage.
+type StageFunc func(ev InputEvent) []InputEvent
+
+func (f StageFunc) Process(ev InputEvent) []InputEvent {
+	return f(ev)
+}
+
+// Return an EventTransformer that passes each event of a batch through
+// stage and concatenates the results.
+func EachEvent(stage Stage) EventTransformer {
+	return TransformFunc(func(events []InputEvent) []InputEvent {
+		out := make([]InputEvent, 0, len(events))
+		for _, ev := range events {
+			out = append(out, stage.Process(ev)...)
+		}
+		return out
+	})
+}
+
+// Destination of the events that come out of a pipeline.
+type EventSink interface {
+	WriteEvents(events []InputEvent) error
+}
+
+// Adapter to use an ordinary function as an EventSink.
+type SinkFunc func(events []InputEvent) error
+
+func (f SinkFunc) WriteEvents(events []InputEvent) error {
+	return f(events)
+}
+
+// Return a sink that sends each batch of events on ch.
+func ChanSink(ch chan<- []InputEvent) EventSink {
+	return SinkFunc(func(events []InputEvent) error {
+		ch <- events
+		return nil
+	})
+}
+
+// Return a sink that writes events to w in the kernel's input_event
+// layout, e.g. to a uinput device or to a device opened with
+// OpenReadWrite.
+func WriterSink(w io.Writer) EventSink {
+	return SinkFunc(func(events []InputEvent) error {
+		return write_events(w, events)
+	})
+}
+
+// Read batches of events from src, pass them through the pipeline and
+// write what comes out to sink, until reading or writing fails. The error
+// is returned; for a source that ends it is io.EOF. Batches that the
+// pipeline reduces to nothing are not written.
+func (p Pipeline) Run(src EventSource, sink EventSink) error {
+	for {
+		events, err := src.Read()
+		if err != nil {
+			return err
+		}
+		if events = p.Transform(events); len(events) == 0 {
+			continue
+		}
+		if err := sink.WriteEvents(events); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// +build linux
+
+package evdev
+
+import "sync"
+
+// What a streaming reader, such as a Broadcaster subscription or a
+// MergedStream, does with newly read events when its consumer has fallen
+// behind and the buffer is full. The dropping policies count the events
+// they drop. Read errors are always delivered, never dropped.
+//
+// OverflowCoalesce merges a new batch that carries nothing but relative
+// motion into the last batch buffered, if that is from the same device
+// and carries nothing but relative motion too, summing the deltas like
+// RelCoalescer. Otherwise it drops the oldest batch like
+// OverflowDropOldest, so events are never reordered.
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota // stop reading until the consumer catches up
+	OverflowDropOldest                       // drop the oldest buffered batch of events
+	OverflowDropNewest                       // drop the newly read batch of events
+	OverflowCoalesce                         // merge relative motion into the last batch buffered, else drop the oldest
+)
+
+// A batch of events read from a device, or the error that ended reading.
+type queued_batch struct {
+	dev    *InputDevice
+	events []InputEvent
+	err    error
+}
+
+// A bounded queue of batches between the goroutine reading a device and
+// the goroutine delivering to a consumer, applying an OverflowPolicy.
+type event_queue struct {
+	mu        sync.Mutex
+	cond      *sync.Cond // signalled whenever the state below changes
+	batches   []queued_batch
+	size      int
+	policy    OverflowPolicy
+	closed    bool   // nothing more will be put
+	cancelled bool   // the consumer is gone
+	dropped   uint64 // number of events dropped
+}
+
+func new_event_queue(size int, policy OverflowPolicy) *event_queue {
+	if size < 1 {
+		size = 1
+	}
+	q := &event_queue{size: size, policy: policy}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Add a batch, applying the overflow policy if the queue is full.
+func (q *event_queue) put(b queued_batch) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for b.err == nil && !q.cancelled && len(q.batches) >= q.size {
+		switch q.policy {
+		case OverflowDropNewest:
+			q.dropped += uint64(len(b.events))
+			return
+		case OverflowCoalesce:
+			last := &q.batches[len(q.batches)-1]
+			if last.dev == b.dev && last.err == nil {
+				if merged, ok := merge_rel(last.events, b.events); ok {
+					last.events = merged
+					return
+				}
+			}
+			fallthrough
+		case OverflowDropOldest:
+			if !q.drop_oldest() {
+				// Only errors are queued; make no room at their expense.
+				q.dropped += uint64(len(b.events))
+				return
+			}
+		default:
+			q.cond.Wait()
+		}
+	}
+	if q.cancelled {
+		return
+	}
+	q.batches = append(q.batches, b)
+	q.cond.Broadcast()
+}
+
+// Drop the oldest batch of events; errors are kept. Returns false if
+// there was no batch of events to drop.
+func (q *event_queue) drop_oldest() bool {
+	for i, b := range q.batches {
+		if b.err == nil {
+			q.dropped += uint64(len(b.events))
+			q.batches = append(q.batches[:i], q.batches[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Remove and return the oldest batch, waiting for one if the queue is
+// empty. Returns false once the queue is closed and empty, or cancelled.
+func (q *event_queue) get() (queued_batch, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.batches) == 0 && !q.closed && !q.cancelled {
+		q.cond.Wait()
+	}
+	if len(q.batches) == 0 || q.cancelled {
+		return queued_batch{}, false
+	}
+	b := q.batches[0]
+	q.batches = q.batches[1:]
+	q.cond.Broadcast()
+	return b, true
+}
+
+// Mark the end of the batches; those queued are still delivered.
+func (q *event_queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Discard the queued batches and any put later.
+func (q *event_queue) cancel() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.cancelled = true
+	q.batches = nil
+	q.cond.Broadcast()
+}
+
+func (q *event_queue) dropped_events() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
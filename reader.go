@@ -0,0 +1,63 @@
+package evdev
+
+import "io"
+
+// A source of input events, implemented by InputDevice and ReaderDevice.
+type EventSource interface {
+	Read() ([]InputEvent, error)
+	ReadOne() (*InputEvent, error)
+}
+
+// Reads events from a byte stream in the kernel's input_event layout, such
+// as a recording of a device node, instead of from a device. This allows
+// code written against EventSource to be tested with recorded input,
+// without access to any device.
+type ReaderDevice struct {
+	r   io.Reader
+	raw []byte
+}
+
+// Create a ReaderDevice reading events from r.
+func NewReaderDevice(r io.Reader) *ReaderDevice {
+	return &ReaderDevice{r: r, raw: make([]byte, eventsize*16)}
+}
+
+// Read and return the next events, up to 16. Unlike a device node, r may
+// return partial events; reads are repeated until whole events are
+// available. At the end of the stream io.EOF is returned, or
+// io.ErrUnexpectedEOF if it ends in the middle of an event.
+func (dev *ReaderDevice) Read() ([]InputEvent, error) {
+	return dev.read(len(dev.raw) / eventsize)
+}
+
+// Read and return the next event.
+func (dev *ReaderDevice) ReadOne() (*InputEvent, error) {
+	events, err := dev.read(1)
+	if err != nil {
+		return nil, err
+	}
+	return &events[0], nil
+}
+
+func (dev *ReaderDevice) read(count int) ([]InputEvent, error) {
+	buffer := dev.raw[:eventsize*count]
+	n, err := io.ReadAtLeast(dev.r, buffer, eventsize)
+	if err != nil {
+		return nil, err
+	}
+	if rest := n % eventsize; rest != 0 {
+		if _, err := io.ReadFull(dev.r, buffer[n:n+eventsize-rest]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		n += eventsize - rest
+	}
+
+	events := make([]InputEvent, n/eventsize)
+	for i := range events {
+		decode_event(buffer[i*eventsize:], &events[i])
+	}
+	return events, nil
+}
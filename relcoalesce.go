@@ -0,0 +1,106 @@
+package evdev
+
+import "time"
+
+// Merges relative motion (EV_REL) events into aggregate deltas, so that a
+// slow consumer of a high-rate mouse handles fewer events. Within a frame
+// (the events up to a SYN_REPORT), all events of one relative axis are
+// summed into the first of them. With a window, consecutive frames that
+// carry nothing but relative motion are merged as well, as long as they
+// start within the window of the first one; the merged frame ends with
+// the SYN_REPORT of the last frame merged. Other events are never merged
+// or reordered, and frames are not held back across calls.
+type RelCoalescer struct {
+	window time.Duration
+}
+
+// Create a RelCoalescer merging frames within window of each other; a
+// window of 0 only merges events within a frame.
+func NewRelCoalescer(window time.Duration) *RelCoalescer {
+	return &RelCoalescer{window: window}
+}
+
+// Coalesce a slice of events (see EventTransformer). The input slice is
+// not modified.
+func (c *RelCoalescer) Transform(events []InputEvent) []InputEvent {
+	out := make([]InputEvent, 0, len(events))
+	group := -1 // start in out of the frame that the next one may merge into
+	var start time.Duration
+
+	for len(events) > 0 {
+		n := frame_end(events) + 1
+		if n == 0 {
+			n = len(events) // incomplete frame
+		}
+		frame := coalesce_rel(events[:n])
+		events = events[n:]
+
+		last := frame[len(frame)-1]
+		if !(last.Type == EV_SYN && last.Code == SYN_REPORT) || !rel_only(frame) {
+			group = -1
+			out = append(out, frame...)
+			continue
+		}
+
+		t := time.Duration(last.Time.Nano())
+		if group >= 0 && t-start < c.window {
+			merged := append(append([]InputEvent{}, out[group:len(out)-1]...), frame...)
+			out = append(out[:group], coalesce_rel(merged)...)
+			continue
+		}
+		group, start = len(out), t
+		out = append(out, frame...)
+	}
+
+	return out
+}
+
+// Sum the events of each relative axis into the first of them.
+func coalesce_rel(events []InputEvent) []InputEvent {
+	out := make([]InputEvent, 0, len(events))
+	index := make(map[uint16]int)
+	for _, ev := range events {
+		if ev.Type == EV_REL {
+			if i, ok := index[ev.Code]; ok {
+				out[i].Value += ev.Value
+				continue
+			}
+			index[ev.Code] = len(out)
+		}
+		out = append(out, ev)
+	}
+	return out
+}
+
+// Merge batch b into batch a if both carry nothing but relative motion,
+// summing the events of each axis as Transform does for frames within the
+// window. The merged batch ends with the SYN_REPORT of b, if any. Returns
+// false if either batch carries other events.
+func merge_rel(a, b []InputEvent) ([]InputEvent, bool) {
+	if !rel_only(a) || !rel_only(b) {
+		return nil, false
+	}
+	merged := make([]InputEvent, 0, len(a)+len(b))
+	for _, events := range [][]InputEvent{a, b} {
+		for _, ev := range events {
+			if ev.Type == EV_REL {
+				merged = append(merged, ev)
+			}
+		}
+	}
+	merged = coalesce_rel(merged)
+	if n := len(b); n > 0 && b[n-1].Type == EV_SYN {
+		merged = append(merged, b[n-1])
+	}
+	return merged, true
+}
+
+// Whether a frame carries nothing but relative motion.
+func rel_only(frame []InputEvent) bool {
+	for _, ev := range frame {
+		if ev.Type != EV_REL && !(ev.Type == EV_SYN && ev.Code == SYN_REPORT) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package evdev
+
+// Rewrites events according to a table of rules, e.g. to swap modifier
+// keys or to make a mouse button type a key chord. There are two kinds of
+// rules: Remap replaces the code of all events of a type and code, and
+// Bind replaces events of a type, code and value with a sequence of
+// events. Bind rules take precedence, and all rules are looked up with the
+// original event, so rules do not chain. A Remapper is both a Stage and an
+// EventTransformer.
+type Remapper struct {
+	codes map[remap_code]uint16
+	binds map[remap_value][]InputEvent
+}
+
+type remap_code struct {
+	evtype, code uint16
+}
+
+type remap_value struct {
+	evtype, code uint16
+	value        int32
+}
+
+// Create a Remapper without rules.
+func NewRemapper() *Remapper {
+	return &Remapper{
+		codes: make(map[remap_code]uint16),
+		binds: make(map[remap_value][]InputEvent),
+	}
+}
+
+// Give events of type evtype with code from the code to instead, e.g.
+// Remap(EV_KEY, KEY_CAPSLOCK, KEY_ESC).
+func (r *Remapper) Remap(evtype, from, to int) {
+	r.codes[remap_code{uint16(evtype), uint16(from)}] = uint16(to)
+}
+
+// Swap two keys, e.g. SwapKeys(KEY_LEFTCTRL, KEY_LEFTMETA).
+func (r *Remapper) SwapKeys(a, b int) {
+	r.Remap(EV_KEY, a, b)
+	r.Remap(EV_KEY, b, a)
+}
+
+// Replace events of type evtype with the given code and value by the
+// events of seq, which take the timestamp of the event they replace. An
+// empty seq drops the events.
+func (r *Remapper) Bind(evtype, code int, value int32, seq []InputEvent) {
+	r.binds[remap_value{uint16(evtype), uint16(code), value}] = append([]InputEvent(nil), seq...)
+}
+
+// Make a press of the key or button code type the key chord spec (see
+// ParseKeySequence), e.g. BindChord(BTN_SIDE, "ctrl+c"). Releases and
+// autorepeats of code are dropped.
+//
+// The chord is typed one key event per frame, so it splits the frame of
+// the press: events that preceded the press in its frame end up in a
+// frame of their own. The chord's last SYN_REPORT is left out, so that
+// its last key event and the events that followed the press are closed
+// by the SYN_REPORT of the original frame.
+func (r *Remapper) BindChord(code int, spec string) error {
+	seq, err := ParseKeySequence(spec)
+	if err != nil {
+		return err
+	}
+	if n := len(seq); n > 0 && seq[n-1].Type == EV_SYN && seq[n-1].Code == SYN_REPORT {
+		seq = seq[:n-1]
+	}
+	r.Bind(EV_KEY, code, int32(KeyDown), seq)
+	r.Bind(EV_KEY, code, int32(KeyUp), nil)
+	r.Bind(EV_KEY, code, int32(KeyHold), nil)
+	return nil
+}
+
+// Apply the rules to an event (see Stage).
+func (r *Remapper) Process(ev InputEvent) []InputEvent {
+	if seq, ok := r.binds[remap_value{ev.Type, ev.Code, ev.Value}]; ok {
+		out := make([]InputEvent, len(seq))
+		for i := range seq {
+			out[i] = seq[i]
+			out[i].Time = ev.Time
+		}
+		return out
+	}
+	if code, ok := r.codes[remap_code{ev.Type, ev.Code}]; ok {
+		ev.Code = code
+	}
+	return []InputEvent{ev}
+}
+
+// Apply the rules to a batch of events (see EventTransformer). The input
+// slice is not modified.
+func (r *Remapper) Transform(events []InputEvent) []InputEvent {
+	return EachEvent(r).Transform(events)
+}
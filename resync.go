@@ -0,0 +1,250 @@
+// +build linux
+
+package evdev
+
+import (
+	"sort"
+	"syscall"
+)
+
+// Reads events from a device and recovers from SYN_DROPPED on its own.
+// When the kernel's event buffer overflows, the events up to the next
+// SYN_REPORT are discarded and replaced by synthetic events that bring
+// the consumer from the state it last saw to the device's current state:
+// key presses and releases, absolute axis values and multitouch slot
+// values, followed by a SYN_REPORT. Consumers that track state from the
+// events they read thereby never operate on a stale view.
+type ResyncingReader struct {
+	read     func() ([]InputEvent, error)
+	query    func() (*device_state, error)
+	state    *device_state // as seen by the consumer
+	dropping bool          // discarding events until SYN_REPORT
+}
+
+// Create a ResyncingReader reading from dev. The device's current state
+// is queried right away as the starting point. Don't read from dev by
+// other means while the ResyncingReader is in use.
+func NewResyncingReader(dev *InputDevice) (*ResyncingReader, error) {
+	return new_resyncing_reader(dev.Read, dev.query_state)
+}
+
+func new_resyncing_reader(read func() ([]InputEvent, error), query func() (*device_state, error)) (*ResyncingReader, error) {
+	state, err := query()
+	if err != nil {
+		return nil, err
+	}
+	return &ResyncingReader{read: read, query: query, state: state}, nil
+}
+
+// Read events like InputDevice.Read. A SYN_DROPPED is never returned;
+// the events that resynchronize state are returned in its place.
+func (r *ResyncingReader) Read() ([]InputEvent, error) {
+	for {
+		events, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		if events, err = r.process(events); err != nil || len(events) > 0 {
+			return events, err
+		}
+	}
+}
+
+func (r *ResyncingReader) process(events []InputEvent) ([]InputEvent, error) {
+	out := make([]InputEvent, 0, len(events))
+	for _, ev := range events {
+		if r.dropping {
+			if ev.Type == EV_SYN && ev.Code == SYN_REPORT {
+				r.dropping = false
+				sync, err := r.resync(ev.Time)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, sync...)
+			}
+			continue
+		}
+		if ev.Type == EV_SYN && ev.Code == SYN_DROPPED {
+			r.dropping = true
+			continue
+		}
+		r.state.apply(ev)
+		out = append(out, ev)
+	}
+	return out, nil
+}
+
+func (r *ResyncingReader) resync(t syscall.Timeval) ([]InputEvent, error) {
+	current, err := r.query()
+	if err != nil {
+		return nil, err
+	}
+	events := r.state.sync_events(current, t)
+	r.state = current
+	return events, nil
+}
+
+// The state of a device that can be queried from the kernel.
+type device_state struct {
+	keys  map[int]bool    // held keys
+	abs   map[int]int32   // values of the axes that are not per slot
+	slots map[int][]int32 // values of the per-slot axes, by slot; nil without ABS_MT_SLOT
+	slot  int32           // current multitouch slot
+}
+
+func new_device_state() *device_state {
+	return &device_state{keys: make(map[int]bool), abs: make(map[int]int32)}
+}
+
+// Query the device's current state.
+func (dev *InputDevice) query_state() (*device_state, error) {
+	s := new_device_state()
+
+	held, err := dev.KeyState()
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range held {
+		if to, ok := dev.keymap[code]; ok {
+			code = to
+		}
+		s.keys[code] = true
+	}
+
+	axes := dev.CapabilitiesFlat[EV_ABS]
+	nslots := 0
+	for _, code := range axes {
+		if code == ABS_MT_SLOT {
+			info, err := dev.AbsInfo(ABS_MT_SLOT)
+			if err != nil {
+				return nil, err
+			}
+			s.slot = info.Value()
+			nslots = int(info.Maximum()) + 1
+			s.slots = make(map[int][]int32)
+		}
+	}
+	for _, code := range axes {
+		switch {
+		case code == ABS_MT_SLOT:
+		case is_mt_slot_axis(code):
+			if nslots == 0 {
+				continue // type A multitouch carries no state
+			}
+			values, err := dev.MTSlots(code, nslots)
+			if err != nil {
+				return nil, err
+			}
+			s.slots[code] = values
+		default:
+			info, err := dev.AbsInfo(code)
+			if err != nil {
+				return nil, err
+			}
+			s.abs[code] = info.Value()
+		}
+	}
+	return s, nil
+}
+
+// Update the state with an event read from the device.
+func (s *device_state) apply(ev InputEvent) {
+	code := int(ev.Code)
+	switch ev.Type {
+	case EV_KEY:
+		if ev.Value == int32(KeyUp) {
+			delete(s.keys, code)
+		} else {
+			s.keys[code] = true
+		}
+	case EV_ABS:
+		switch {
+		case code == ABS_MT_SLOT:
+			s.slot = ev.Value
+		case is_mt_slot_axis(code):
+			if values, ok := s.slots[code]; ok && s.slot >= 0 && int(s.slot) < len(values) {
+				values[s.slot] = ev.Value
+			}
+		default:
+			if _, ok := s.abs[code]; ok {
+				s.abs[code] = ev.Value
+			}
+		}
+	}
+}
+
+// Return the events that take a consumer from state s to state to,
+// ending with a SYN_REPORT, or nothing if the states are the same.
+func (s *device_state) sync_events(to *device_state, t syscall.Timeval) []InputEvent {
+	events := make([]InputEvent, 0)
+	emit := func(evtype, code int, value int32) {
+		events = append(events, InputEvent{Time: t, Type: uint16(evtype), Code: uint16(code), Value: value})
+	}
+
+	codes := make([]int, 0, len(s.keys)+len(to.keys))
+	for code := range s.keys {
+		if !to.keys[code] {
+			codes = append(codes, code)
+		}
+	}
+	for code := range to.keys {
+		if !s.keys[code] {
+			codes = append(codes, code)
+		}
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		if to.keys[code] {
+			emit(EV_KEY, code, int32(KeyDown))
+		} else {
+			emit(EV_KEY, code, int32(KeyUp))
+		}
+	}
+
+	for _, code := range sorted_state_codes(to.abs) {
+		if s.abs[code] != to.abs[code] {
+			emit(EV_ABS, code, to.abs[code])
+		}
+	}
+
+	slot := s.slot
+	axes := make([]int, 0, len(to.slots))
+	nslots := 0
+	for code, values := range to.slots {
+		axes = append(axes, code)
+		if len(values) > nslots {
+			nslots = len(values)
+		}
+	}
+	sort.Ints(axes)
+	for i := 0; i < nslots; i++ {
+		for _, code := range axes {
+			old, values := s.slots[code], to.slots[code]
+			if i >= len(values) || i < len(old) && old[i] == values[i] {
+				continue
+			}
+			if slot != int32(i) {
+				slot = int32(i)
+				emit(EV_ABS, ABS_MT_SLOT, slot)
+			}
+			emit(EV_ABS, code, values[i])
+		}
+	}
+	if slot != to.slot {
+		emit(EV_ABS, ABS_MT_SLOT, to.slot)
+	}
+
+	if len(events) > 0 {
+		emit(EV_SYN, SYN_REPORT, 0)
+	}
+	return events
+}
+
+func sorted_state_codes(values map[int]int32) []int {
+	codes := make([]int, 0, len(values))
+	for code := range values {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	return codes
+}
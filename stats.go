@@ -0,0 +1,67 @@
+package evdev
+
+import (
+	"sync"
+	"time"
+)
+
+// Statistics of the events read from a device; see InputDevice.Stats.
+type DeviceStats struct {
+	Events    uint64    // number of events read
+	Bytes     uint64    // number of bytes read
+	Overruns  uint64    // number of SYN_DROPPED events, i.e. kernel buffer overflows
+	LastEvent time.Time // kernel timestamp of the latest event; zero if none
+	Rate      float64   // events per second, measured over about a second
+}
+
+// Maintains DeviceStats for the read path.
+type read_stats struct {
+	mu      sync.Mutex
+	stats   DeviceStats
+	start   time.Time // start of the current rate window
+	counted uint64    // events read in the current window
+}
+
+func (s *read_stats) add(events []InputEvent) {
+	if len(events) == 0 {
+		return
+	}
+	now := clk.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := uint64(len(events))
+	s.stats.Events += n
+	s.stats.Bytes += n * uint64(eventsize)
+	for _, ev := range events {
+		if ev.Type == EV_SYN && ev.Code == SYN_DROPPED {
+			s.stats.Overruns++
+		}
+	}
+	s.stats.LastEvent = events[len(events)-1].Timestamp()
+
+	if s.start.IsZero() {
+		s.start = now
+	}
+	s.counted += n
+	if elapsed := now.Sub(s.start); elapsed >= time.Second {
+		s.stats.Rate = float64(s.counted) / elapsed.Seconds()
+		s.start, s.counted = now, 0
+	}
+}
+
+func (s *read_stats) snapshot() DeviceStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	// A window that has gone on for longer than a second without being
+	// closed by a read reflects a device that has gone quiet.
+	if !s.start.IsZero() {
+		if elapsed := clk.Now().Sub(s.start); elapsed >= time.Second {
+			stats.Rate = float64(s.counted) / elapsed.Seconds()
+		}
+	}
+	return stats
+}
@@ -0,0 +1,143 @@
+// +build linux
+
+package evdev
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+)
+
+// How the streaming methods (Events, EncodeEvents and WaitForRelease)
+// handle read errors. Errors are classified as follows:
+//
+//	EINTR, EAGAIN      transient: the read is retried at once
+//	ErrDeviceHangup    the device is gone (ENODEV): the error ends the
+//	                   stream, unless Reconnect is set
+//	anything else      fatal: the error ends the stream; this includes
+//	                   ErrRevoked, ErrNotOpen, io.EOF and ctx.Err()
+//
+// With Reconnect, a hung up device is reopened like Reopen, retrying with
+// exponential backoff from 100ms up to MaxBackoff (default 5s) until it
+// succeeds or the context is done, and the stream then resumes. After an
+// unplug the device node may be given to another device, so a node whose
+// bus type, vendor, product or uniq differ is not accepted and retrying
+// goes on. Events that occurred while the device was gone are lost.
+type ReadErrorPolicy struct {
+	Reconnect  bool
+	MaxBackoff time.Duration
+}
+
+const (
+	reconnect_initial_backoff = 100 * time.Millisecond
+	reconnect_max_backoff     = 5 * time.Second
+)
+
+// Set the read error policy of the streaming methods; the zero policy,
+// which is the default, does not reconnect.
+func (dev *InputDevice) SetReadErrorPolicy(policy ReadErrorPolicy) {
+	dev.policy = policy
+}
+
+type read_error_class int
+
+const (
+	read_ok read_error_class = iota
+	read_transient
+	read_hangup
+	read_fatal
+)
+
+func classify_read_error(err error) read_error_class {
+	switch err {
+	case nil:
+		return read_ok
+	case syscall.EINTR, syscall.EAGAIN:
+		return read_transient
+	case ErrDeviceHangup:
+		return read_hangup
+	}
+	return read_fatal
+}
+
+// Read the next batch of events for a stream, applying the device's read
+// error policy.
+func (dev *InputDevice) stream_read(ctx context.Context) ([]InputEvent, error) {
+	return read_with_policy(ctx, dev.policy, dev.readContext, dev.reopen_same)
+}
+
+// Returned when a reopened device node belongs to another device.
+var err_device_changed = errors.New("evdev: device node belongs to another device")
+
+// Reopen the device like Reopen, but fail with err_device_changed if the
+// node now belongs to another device.
+func (dev *InputDevice) reopen_same() error {
+	open := func() (*InputDevice, error) { return open_device(dev.Fn, dev.flags) }
+	return dev.reopen(open_same(dev, open), (*InputDevice).grab)
+}
+
+// Wrap open so that it fails with err_device_changed, closing what it
+// opened, unless that is the same device as dev.
+func open_same(dev *InputDevice, open func() (*InputDevice, error)) func() (*InputDevice, error) {
+	return func() (*InputDevice, error) {
+		fresh, err := open()
+		if err != nil {
+			return nil, err
+		}
+		if fresh.Bustype != dev.Bustype || fresh.Vendor != dev.Vendor ||
+			fresh.Product != dev.Product || fresh.Uniq != dev.Uniq {
+			fresh.Close()
+			return nil, err_device_changed
+		}
+		return fresh, nil
+	}
+}
+
+func read_with_policy(ctx context.Context, policy ReadErrorPolicy,
+	read func(context.Context) ([]InputEvent, error), reopen func() error) ([]InputEvent, error) {
+	for {
+		events, err := read(ctx)
+		switch classify_read_error(err) {
+		case read_ok:
+			return events, nil
+		case read_transient:
+			continue
+		case read_hangup:
+			if !policy.Reconnect {
+				return nil, err
+			}
+			if err := reconnect(ctx, policy, reopen); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+	}
+}
+
+// Call reopen until it succeeds, backing off exponentially in between.
+func reconnect(ctx context.Context, policy ReadErrorPolicy, reopen func() error) error {
+	max := policy.MaxBackoff
+	if max <= 0 {
+		max = reconnect_max_backoff
+	}
+
+	delay := reconnect_initial_backoff
+	if delay > max {
+		delay = max
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clk.After(delay):
+		}
+		if reopen() == nil {
+			return nil
+		}
+		if delay *= 2; delay > max {
+			delay = max
+		}
+	}
+}
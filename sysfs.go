@@ -0,0 +1,208 @@
+// +build linux
+
+package evdev
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Root of the sysfs mount; tests point it at a fake tree.
+var sysfs_root = "/sys"
+
+// Return the sysfs directory of the input device (inputN) that the event
+// node belongs to, e.g. /sys/class/input/event3/device.
+func (dev *InputDevice) SysfsPath() string {
+	node := filepath.Base(resolve_devnode(dev.Fn))
+	return filepath.Join(sysfs_root, "class/input", node, "device")
+}
+
+// Return N of the device's /dev/input/eventN node. A devnode that is a
+// symlink (e.g. under /dev/input/by-id) is resolved first.
+func (dev *InputDevice) EventNumber() (int, error) {
+	return node_number(filepath.Base(resolve_devnode(dev.Fn)), "event")
+}
+
+// Return M of the input device (/sys/class/input/inputM) that the event
+// node belongs to.
+func (dev *InputDevice) DeviceNumber() (int, error) {
+	dir, err := filepath.EvalSymlinks(dev.SysfsPath())
+	if err != nil {
+		return 0, err
+	}
+	return node_number(filepath.Base(dir), "input")
+}
+
+// Parse the number of a node name such as event12 or input5.
+func node_number(name string, prefix string) (int, error) {
+	if !strings.HasPrefix(name, prefix) {
+		return 0, fmt.Errorf("%q is not an %s node", name, prefix)
+	}
+	n, err := strconv.Atoi(name[len(prefix):])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not an %s node", name, prefix)
+	}
+	return n, nil
+}
+
+// Fill in Name and Uniq from sysfs if the ioctls left them empty, as
+// happens when the ioctls are restricted.
+func (dev *InputDevice) set_sysfs_info() {
+	dir := dev.SysfsPath()
+	if dev.Name == "" {
+		dev.Name = read_sysfs_attr(filepath.Join(dir, "name"))
+	}
+	if dev.Uniq == "" {
+		dev.Uniq = read_sysfs_attr(filepath.Join(dir, "uniq"))
+	}
+}
+
+// Read a single-line sysfs attribute; an unreadable attribute is empty.
+func read_sysfs_attr(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(data), "\n")
+}
+
+// An LED of an input device as exposed by the sysfs LED class, e.g. the
+// caps lock LED of a keyboard at /sys/class/leds/input5::capslock.
+type SysfsLED struct {
+	Name string // LED class device name, e.g. "input5::capslock"
+	Path string // sysfs directory of the LED
+}
+
+// Find the LEDs of the device in sysfs. The LED class devices of an input
+// device live in its sysfs directory (or its leds subdirectory), and can be
+// controlled through them even when the event node has no EV_LED.
+func (dev *InputDevice) SysfsLEDs() ([]SysfsLED, error) {
+	return ReadSysfsLEDs(dev.SysfsPath())
+}
+
+// Find the LEDs in the sysfs directory of an input device
+// (/sys/class/input/inputN). See SysfsLEDs.
+func ReadSysfsLEDs(dir string) ([]SysfsLED, error) {
+	leds := make([]SysfsLED, 0)
+	for _, parent := range []string{dir, filepath.Join(dir, "leds")} {
+		entries, err := ioutil.ReadDir(parent)
+		if err != nil {
+			if parent == dir {
+				return nil, err
+			}
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(parent, entry.Name())
+			if !strings.Contains(entry.Name(), "::") {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(path, "brightness")); err == nil {
+				leds = append(leds, SysfsLED{entry.Name(), path})
+			}
+		}
+	}
+	return leds, nil
+}
+
+// Return the function part of the LED name, e.g. "capslock".
+func (led SysfsLED) Function() string {
+	return led.Name[strings.LastIndex(led.Name, "::")+2:]
+}
+
+// Get the current brightness of the LED (0 is off).
+func (led SysfsLED) Brightness() (int, error) {
+	return strconv.Atoi(read_sysfs_attr(filepath.Join(led.Path, "brightness")))
+}
+
+// Set the brightness of the LED (0 is off). Writing to sysfs usually
+// requires root.
+func (led SysfsLED) SetBrightness(value int) error {
+	return ioutil.WriteFile(filepath.Join(led.Path, "brightness"), []byte(strconv.Itoa(value)), 0644)
+}
+
+// Capability bitmap files in an input device's sysfs capabilities
+// directory, by event type.
+var sysfs_capability_files = map[int]string{
+	EV_KEY: "key",
+	EV_REL: "rel",
+	EV_ABS: "abs",
+	EV_MSC: "msc",
+	EV_LED: "led",
+	EV_SND: "snd",
+	EV_FF:  "ff",
+	EV_SW:  "sw",
+}
+
+// Read the device's capabilities from sysfs instead of through ioctls on
+// the device node. This works without opening the device, and therefore
+// without access to it. The result has the same form as Capabilities,
+// except that EV_SYN codes are not available through sysfs.
+func (dev *InputDevice) SysfsCapabilities() (map[CapabilityType][]CapabilityCode, error) {
+	return ReadSysfsCapabilities(dev.SysfsPath())
+}
+
+// Read the capabilities from the sysfs directory of an input device
+// (/sys/class/input/inputN). See SysfsCapabilities.
+func ReadSysfsCapabilities(dir string) (map[CapabilityType][]CapabilityCode, error) {
+	evbits, err := read_sysfs_bitmap(filepath.Join(dir, "capabilities", "ev"))
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities := make(map[CapabilityType][]CapabilityCode)
+	for _, evtype := range evbits {
+		eventcodes := make([]CapabilityCode, 0)
+
+		if name, ok := sysfs_capability_files[evtype]; ok {
+			codes, err := read_sysfs_bitmap(filepath.Join(dir, "capabilities", name))
+			if err != nil {
+				return nil, err
+			}
+			for _, code := range codes {
+				eventcodes = append(eventcodes, CapabilityCode{code, CodeName(evtype, code)})
+			}
+		}
+
+		capabilities[CapabilityType{evtype, TypeName(evtype)}] = eventcodes
+	}
+
+	return capabilities, nil
+}
+
+func read_sysfs_bitmap(path string) ([]int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSysfsBitmap(string(data))
+}
+
+// Parse a bitmap as printed by the kernel in sysfs: space separated
+// hexadecimal words of the native word size, most significant word first
+// (e.g. "10000 0 0 0 0" or "120013"). The numbers of the set bits are
+// returned in ascending order.
+func ParseSysfsBitmap(s string) ([]int, error) {
+	words := strings.Fields(s)
+	codes := make([]int, 0)
+
+	for i := len(words) - 1; i >= 0; i-- {
+		word, err := strconv.ParseUint(words[i], 16, bits.UintSize)
+		if err != nil {
+			return nil, err
+		}
+		base := (len(words) - 1 - i) * bits.UintSize
+		for bit := 0; bit < bits.UintSize; bit++ {
+			if word&(1<<uint(bit)) != 0 {
+				codes = append(codes, base+bit)
+			}
+		}
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,87 @@
+// +build linux
+
+package evdev
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Return the key under which systemd's hwdb stores evdev quirks for this
+// device, e.g.
+//
+//	evdev:input:b0003v046DpC069e0110-e0,1,2,4,k110,111,112,r0,1,8,am4,lsfw
+//
+// It is "evdev:" followed by the device's modalias, built the same way the
+// kernel builds it from the ids and capability bitmaps.
+func (dev *InputDevice) HwdbKey() string {
+	return "evdev:" + modalias(dev.Bustype, dev.Vendor, dev.Product, dev.Version, dev.Capabilities)
+}
+
+// Sections of the input modalias, in kernel order (see input_print_modalias).
+var modalias_sections = []struct {
+	prefix byte
+	evtype int
+	min    int
+}{
+	{'e', -1, 0},
+	{'k', EV_KEY, KEY_MIN_INTERESTING},
+	{'r', EV_REL, 0},
+	{'a', EV_ABS, 0},
+	{'m', EV_MSC, 0},
+	{'l', EV_LED, 0},
+	{'s', EV_SND, 0},
+	{'f', EV_FF, 0},
+	{'w', EV_SW, 0},
+}
+
+func modalias(bustype, vendor, product, version uint16, capabilities map[CapabilityType][]CapabilityCode) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "input:b%04Xv%04Xp%04Xe%04X-", bustype, vendor, product, version)
+
+	for _, section := range modalias_sections {
+		b.WriteByte(section.prefix)
+
+		if section.evtype < 0 {
+			for _, ctype := range sorted_capability_types(capabilities) {
+				fmt.Fprintf(&b, "%X,", ctype.Type)
+			}
+			continue
+		}
+
+		for ctype, codes := range capabilities {
+			if ctype.Type != section.evtype {
+				continue
+			}
+			for _, code := range sorted_capability_codes(codes) {
+				if code.Code >= section.min {
+					fmt.Fprintf(&b, "%X,", code.Code)
+				}
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// Return a udev rule matching this device, as a starting point for rules
+// that set permissions or symlinks. Example:
+//
+//	SUBSYSTEM=="input", ATTRS{idVendor}=="046d", ATTRS{idProduct}=="c069", MODE="0660", GROUP="input"
+//
+// USB devices are matched on the USB parent's idVendor/idProduct; other
+// buses use the input device's own id/vendor and id/product attributes.
+// If withName is set the device name is matched too.
+func (dev *InputDevice) UdevRuleStub(withName bool) string {
+	vendor, product := "ATTRS{id/vendor}", "ATTRS{id/product}"
+	if dev.Bustype == BUS_USB {
+		vendor, product = "ATTRS{idVendor}", "ATTRS{idProduct}"
+	}
+
+	rule := fmt.Sprintf(`SUBSYSTEM=="input", %s=="%04x", %s=="%04x"`, vendor, dev.Vendor, product, dev.Product)
+	if withName {
+		// udev has no quote escaping; '?' matches any single character
+		rule += fmt.Sprintf(`, ATTRS{name}=="%s"`, strings.Replace(dev.Name, `"`, "?", -1))
+	}
+	return rule + `, MODE="0660", GROUP="input"`
+}
@@ -0,0 +1,116 @@
+package evdev
+
+// X11 keycodes of the evdev driver are the key codes offset by 8.
+const X11KeycodeOffset = 8
+
+// Return the X11 keycode (as used by the evdev and libinput X drivers) of
+// a key code.
+func KeyToX11Keycode(code int) int {
+	return code + X11KeycodeOffset
+}
+
+// Return the key code of an X11 keycode; see KeyToX11Keycode.
+func X11KeycodeToKey(keycode int) int {
+	return keycode - X11KeycodeOffset
+}
+
+// Keysyms of the common keys on a US layout, without modifiers.
+var key_keysyms = map[int]uint32{
+	KEY_ESC:        0xff1b, // Escape
+	KEY_BACKSPACE:  0xff08, // BackSpace
+	KEY_TAB:        0xff09, // Tab
+	KEY_ENTER:      0xff0d, // Return
+	KEY_SPACE:      0x0020, // space
+	KEY_MINUS:      0x002d, // minus
+	KEY_EQUAL:      0x003d, // equal
+	KEY_LEFTBRACE:  0x005b, // bracketleft
+	KEY_RIGHTBRACE: 0x005d, // bracketright
+	KEY_BACKSLASH:  0x005c, // backslash
+	KEY_SEMICOLON:  0x003b, // semicolon
+	KEY_APOSTROPHE: 0x0027, // apostrophe
+	KEY_GRAVE:      0x0060, // grave
+	KEY_COMMA:      0x002c, // comma
+	KEY_DOT:        0x002e, // period
+	KEY_SLASH:      0x002f, // slash
+
+	KEY_LEFTSHIFT:  0xffe1, // Shift_L
+	KEY_RIGHTSHIFT: 0xffe2, // Shift_R
+	KEY_LEFTCTRL:   0xffe3, // Control_L
+	KEY_RIGHTCTRL:  0xffe4, // Control_R
+	KEY_CAPSLOCK:   0xffe5, // Caps_Lock
+	KEY_LEFTALT:    0xffe9, // Alt_L
+	KEY_RIGHTALT:   0xffea, // Alt_R
+	KEY_LEFTMETA:   0xffeb, // Super_L
+	KEY_RIGHTMETA:  0xffec, // Super_R
+	KEY_COMPOSE:    0xff67, // Menu
+
+	KEY_SYSRQ:      0xff61, // Print
+	KEY_SCROLLLOCK: 0xff14, // Scroll_Lock
+	KEY_PAUSE:      0xff13, // Pause
+	KEY_INSERT:     0xff63, // Insert
+	KEY_DELETE:     0xffff, // Delete
+	KEY_HOME:       0xff50, // Home
+	KEY_END:        0xff57, // End
+	KEY_PAGEUP:     0xff55, // Prior
+	KEY_PAGEDOWN:   0xff56, // Next
+	KEY_LEFT:       0xff51, // Left
+	KEY_UP:         0xff52, // Up
+	KEY_RIGHT:      0xff53, // Right
+	KEY_DOWN:       0xff54, // Down
+
+	KEY_NUMLOCK:    0xff7f, // Num_Lock
+	KEY_KPENTER:    0xff8d, // KP_Enter
+	KEY_KPASTERISK: 0xffaa, // KP_Multiply
+	KEY_KPPLUS:     0xffab, // KP_Add
+	KEY_KPMINUS:    0xffad, // KP_Subtract
+	KEY_KPDOT:      0xffae, // KP_Decimal
+	KEY_KPSLASH:    0xffaf, // KP_Divide
+}
+
+var keysym_keys = map[uint32]int{}
+
+func init() {
+	letters := []int{
+		KEY_A, KEY_B, KEY_C, KEY_D, KEY_E, KEY_F, KEY_G, KEY_H, KEY_I,
+		KEY_J, KEY_K, KEY_L, KEY_M, KEY_N, KEY_O, KEY_P, KEY_Q, KEY_R,
+		KEY_S, KEY_T, KEY_U, KEY_V, KEY_W, KEY_X, KEY_Y, KEY_Z,
+	}
+	for i, code := range letters {
+		key_keysyms[code] = 'a' + uint32(i)
+	}
+	digits := []int{KEY_0, KEY_1, KEY_2, KEY_3, KEY_4, KEY_5, KEY_6, KEY_7, KEY_8, KEY_9}
+	keypad := []int{KEY_KP0, KEY_KP1, KEY_KP2, KEY_KP3, KEY_KP4, KEY_KP5, KEY_KP6, KEY_KP7, KEY_KP8, KEY_KP9}
+	for i := range digits {
+		key_keysyms[digits[i]] = '0' + uint32(i)
+		key_keysyms[keypad[i]] = 0xffb0 + uint32(i) // KP_0 - KP_9
+	}
+	functions := []int{
+		KEY_F1, KEY_F2, KEY_F3, KEY_F4, KEY_F5, KEY_F6,
+		KEY_F7, KEY_F8, KEY_F9, KEY_F10, KEY_F11, KEY_F12,
+	}
+	for i, code := range functions {
+		key_keysyms[code] = 0xffbe + uint32(i) // F1 - F12
+	}
+
+	for code, keysym := range key_keysyms {
+		keysym_keys[keysym] = code
+	}
+}
+
+// Map a key code to the X11 keysym it produces on a US layout without
+// modifiers. Only the common Latin, function, navigation, keypad and
+// modifier keys are covered.
+func KeyToKeysym(code int) (uint32, bool) {
+	keysym, ok := key_keysyms[code]
+	return keysym, ok
+}
+
+// Map an X11 keysym to the key code that produces it on a US layout; see
+// KeyToKeysym. Upper case letters map to the key of the letter.
+func KeysymToKey(keysym uint32) (int, bool) {
+	if keysym >= 'A' && keysym <= 'Z' {
+		keysym += 'a' - 'A'
+	}
+	code, ok := keysym_keys[keysym]
+	return code, ok
+}